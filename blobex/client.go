@@ -0,0 +1,391 @@
+package blobex
+
+import (
+	"bytes"
+	"encoding/hex"
+	"sync"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const (
+	hashesCheckBatchSize = 100 // max number of hashes sent in one DownloadCheck or UploadCheck request
+	maxBlobAttempts      = 3   // how many times a blob is requested or sent before giving up on it
+)
+
+// Client downloads blobs from and uploads blobs to a BlobExchange server
+type Client struct {
+	rpc      BlobExchangeClient
+	sessions int
+}
+
+// NewClient returns a client that talks to the server on the other end of the connection
+func NewClient(cc *grpc.ClientConn) *Client {
+	return &Client{
+		rpc:      NewBlobExchangeClient(cc),
+		sessions: 1,
+	}
+}
+
+// Sessions sets the number of parallel streams used for downloads and uploads. Blobs are split evenly between them.
+func (c *Client) Sessions(n int) *Client {
+	if n < 1 {
+		n = 1
+	}
+	c.sessions = n
+	return c
+}
+
+// PriceCheck returns the server's price in deweys per KB
+func (c *Client) PriceCheck(ctx context.Context) (uint64, error) {
+	res, err := c.rpc.PriceCheck(ctx, &PriceCheckRequest{})
+	if err != nil {
+		return 0, errors.Err(err)
+	}
+	if res.GetError() != nil {
+		return 0, responseError(res.GetError())
+	}
+	return res.GetDeweysPerKB(), nil
+}
+
+// DownloadCheck returns which of the hashes are available for download
+func (c *Client) DownloadCheck(ctx context.Context, hashes []string) (map[string]bool, error) {
+	return c.hashesCheck(ctx, hashes, c.rpc.DownloadCheck)
+}
+
+// UploadCheck returns which of the hashes the server wants. The result may contain hashes that were not requested, if
+// the server has a stream but is missing some of its content blobs.
+func (c *Client) UploadCheck(ctx context.Context, hashes []string) (map[string]bool, error) {
+	return c.hashesCheck(ctx, hashes, c.rpc.UploadCheck)
+}
+
+func (c *Client) hashesCheck(ctx context.Context, hashes []string,
+	check func(context.Context, *HashesRequest, ...grpc.CallOption) (*HashesResponse, error)) (map[string]bool, error) {
+	result := make(map[string]bool, len(hashes))
+	for start := 0; start < len(hashes); start += hashesCheckBatchSize {
+		end := start + hashesCheckBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+
+		res, err := check(ctx, &HashesRequest{Hashes: hashes[start:end]})
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+		if res.GetError() != nil {
+			return nil, responseError(res.GetError())
+		}
+		for h, ok := range res.GetHashes() {
+			result[h] = ok
+		}
+	}
+	return result, nil
+}
+
+// Download downloads the blobs for the given hashes and verifies them. Blobs that fail to download are retried. If
+// any blob can't be downloaded, the blobs that were downloaded are returned along with an error.
+func (c *Client) Download(ctx context.Context, hashes []string) (map[string]stream.Blob, error) {
+	blobs := make(map[string]stream.Blob, len(hashes))
+	var mu sync.Mutex
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for _, batch := range split(unique(hashes), c.sessions) {
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			downloaded, err := c.downloadSession(ctx, batch)
+			mu.Lock()
+			defer mu.Unlock()
+			for h, b := range downloaded {
+				blobs[h] = b
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	return blobs, firstErr
+}
+
+// downloadSession pipelines requests for all the hashes over a single Download stream
+func (c *Client) downloadSession(ctx context.Context, hashes []string) (map[string]stream.Blob, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	dl, err := c.rpc.Download(ctx)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+
+	s := newSession(hashes, func(h string) error { return dl.Send(&DownloadRequest{Hash: h}) }, dl.CloseSend)
+	defer s.close()
+
+	blobs := make(map[string]stream.Blob, len(hashes))
+	for s.pending > 0 {
+		res, err := dl.Recv()
+		if err != nil {
+			return blobs, errors.Err(err)
+		}
+
+		hash := res.GetHash()
+		if res.GetError() != nil {
+			s.fail(hash, responseError(res.GetError()))
+			continue
+		}
+
+		blob := stream.Blob(res.GetBlob())
+		expected, err := hex.DecodeString(hash)
+		if err != nil || !bytes.Equal(blob.Hash(), expected) {
+			s.fail(hash, errors.Err("blob does not match hash %s", hash))
+			continue
+		}
+
+		if s.done(hash) {
+			blobs[hash] = blob
+		}
+	}
+
+	return blobs, s.finish()
+}
+
+// DownloadStream downloads the sd blob for the given hash, and then all the content blobs it lists. The stream is
+// returned with the sd blob first and the content blobs in order.
+func (c *Client) DownloadStream(ctx context.Context, sdHash string) (stream.Stream, error) {
+	sdBlobs, err := c.Download(ctx, []string{sdHash})
+	if err != nil {
+		return nil, errors.Prefix("downloading sd blob", err)
+	}
+
+	sd := &stream.SDBlob{}
+	err = sd.FromBlob(sdBlobs[sdHash])
+	if err != nil {
+		return nil, errors.Prefix("parsing sd blob", err)
+	}
+
+	var hashes []string
+	for _, info := range sd.BlobInfos {
+		if info.Length == 0 {
+			continue // terminating blob
+		}
+		hashes = append(hashes, hex.EncodeToString(info.BlobHash))
+	}
+
+	blobs, err := c.Download(ctx, hashes)
+	if err != nil {
+		return nil, errors.Prefix("downloading content blobs", err)
+	}
+
+	s := make(stream.Stream, 0, len(hashes)+1)
+	s = append(s, sdBlobs[sdHash])
+	for _, h := range hashes {
+		s = append(s, blobs[h])
+	}
+	return s, nil
+}
+
+// Upload uploads the blobs that the server wants. Blobs that the server does not want are skipped.
+func (c *Client) Upload(ctx context.Context, blobs []stream.Blob) error {
+	byHash := make(map[string]stream.Blob, len(blobs))
+	hashes := make([]string, 0, len(blobs))
+	for _, b := range blobs {
+		h := b.HashHex()
+		if _, ok := byHash[h]; !ok {
+			hashes = append(hashes, h)
+		}
+		byHash[h] = b
+	}
+
+	wanted, err := c.UploadCheck(ctx, hashes)
+	if err != nil {
+		return err
+	}
+
+	var toUpload []string
+	for _, h := range hashes {
+		if wanted[h] {
+			toUpload = append(toUpload, h)
+		}
+	}
+
+	var mu sync.Mutex
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for _, batch := range split(toUpload, c.sessions) {
+		wg.Add(1)
+		go func(batch []string) {
+			defer wg.Done()
+			err := c.uploadSession(ctx, batch, byHash)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}(batch)
+	}
+	wg.Wait()
+
+	return firstErr
+}
+
+// uploadSession pipelines all the blobs over a single Upload stream
+func (c *Client) uploadSession(ctx context.Context, hashes []string, blobs map[string]stream.Blob) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	up, err := c.rpc.Upload(ctx)
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	s := newSession(hashes, func(h string) error { return up.Send(&UploadRequest{Hash: h, Blob: blobs[h]}) }, up.CloseSend)
+	defer s.close()
+
+	for s.pending > 0 {
+		res, err := up.Recv()
+		if err != nil {
+			return errors.Err(err)
+		}
+
+		if res.GetError() != nil {
+			if res.GetError().GetCode() == ErrorCodeInternal {
+				s.fail(res.GetHash(), responseError(res.GetError()))
+			} else {
+				s.giveUp(res.GetHash(), responseError(res.GetError())) // retrying won't help
+			}
+			continue
+		}
+
+		s.done(res.GetHash())
+	}
+
+	return s.finish()
+}
+
+// session tracks the requests that are in flight on one stream. All sends happen in one goroutine, since grpc streams
+// don't allow concurrent sends.
+type session struct {
+	toSend   chan string
+	sendDone chan error
+	closed   bool
+
+	attempts map[string]int
+	pending  int
+	failed   map[string]error
+}
+
+func newSession(hashes []string, send func(string) error, closeSend func() error) *session {
+	s := &session{
+		toSend:   make(chan string, len(hashes)), // never more than one queued send per pending hash
+		sendDone: make(chan error, 1),
+		attempts: make(map[string]int, len(hashes)),
+		pending:  len(hashes),
+		failed:   make(map[string]error),
+	}
+
+	for _, h := range hashes {
+		s.attempts[h] = 1
+		s.toSend <- h
+	}
+
+	go func() {
+		for h := range s.toSend {
+			err := send(h)
+			if err != nil {
+				s.sendDone <- errors.Err(err)
+				for range s.toSend {
+					// drain so the receiver never blocks
+				}
+				return
+			}
+		}
+		s.sendDone <- errors.Err(closeSend())
+	}()
+
+	return s
+}
+
+// done marks the hash as successful. It returns false if the hash was not requested or is already finished.
+func (s *session) done(hash string) bool {
+	if _, ok := s.attempts[hash]; !ok {
+		return false
+	}
+	delete(s.attempts, hash)
+	s.pending--
+	return true
+}
+
+// fail retries the hash, or gives up on it if it has been tried too many times
+func (s *session) fail(hash string, err error) {
+	attempts, ok := s.attempts[hash]
+	if !ok {
+		return
+	}
+	if attempts >= maxBlobAttempts {
+		s.giveUp(hash, err)
+		return
+	}
+	s.attempts[hash]++
+	s.toSend <- hash
+}
+
+func (s *session) giveUp(hash string, err error) {
+	if _, ok := s.attempts[hash]; !ok {
+		return
+	}
+	delete(s.attempts, hash)
+	s.failed[hash] = err
+	s.pending--
+}
+
+// finish waits for the sender to finish and returns an error if any hashes failed
+func (s *session) finish() error {
+	s.close()
+	err := <-s.sendDone
+	for h, e := range s.failed {
+		return errors.Err("%d blobs failed, including %s: %s", len(s.failed), h, e.Error())
+	}
+	return err
+}
+
+func (s *session) close() {
+	if !s.closed {
+		s.closed = true
+		close(s.toSend)
+	}
+}
+
+func responseError(e *Error) error {
+	return errors.Err("error %d: %s", e.GetCode(), e.GetMessage())
+}
+
+// split divides the hashes into at most n batches of roughly equal size
+func split(hashes []string, n int) [][]string {
+	if n > len(hashes) {
+		n = len(hashes)
+	}
+	batches := make([][]string, n)
+	for i, h := range hashes {
+		batches[i%n] = append(batches[i%n], h)
+	}
+	return batches
+}
+
+func unique(hashes []string) []string {
+	seen := make(map[string]bool, len(hashes))
+	var u []string
+	for _, h := range hashes {
+		if !seen[h] {
+			seen[h] = true
+			u = append(u, h)
+		}
+	}
+	return u
+}
@@ -0,0 +1,118 @@
+package blobex
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/store"
+	"github.com/lbryio/lbry.go/v2/stream"
+
+	"golang.org/x/net/context"
+)
+
+// flakyStore fails the first Get for every hash
+type flakyStore struct {
+	*store.MemoryStore
+	failedOnce sync.Map
+}
+
+func (f *flakyStore) Get(hash string) (stream.Blob, error) {
+	if _, failed := f.failedOnce.LoadOrStore(hash, true); !failed {
+		return nil, errors.Err("temporary failure")
+	}
+	return f.MemoryStore.Get(hash)
+}
+
+func TestClient_DownloadStream(t *testing.T) {
+	bs := store.NewMemoryStore()
+	s := testStream(t, 5*stream.MaxBlobSize+100)
+	for _, b := range s {
+		_ = bs.Put(b.HashHex(), b)
+	}
+
+	client := NewClient(testServer(t, NewServer(&flakyStore{MemoryStore: bs}, 3, ""))).Sessions(3)
+
+	price, err := client.PriceCheck(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if price != 3 {
+		t.Errorf("expected price of 3, got %d", price)
+	}
+
+	downloaded, err := client.DownloadStream(context.Background(), s[0].HashHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(downloaded) != len(s) {
+		t.Fatalf("expected %d blobs, got %d", len(s), len(downloaded))
+	}
+	for i := range s {
+		if !bytes.Equal(s[i], downloaded[i]) {
+			t.Errorf("blob %d does not match", i)
+		}
+	}
+}
+
+func TestClient_DownloadMissing(t *testing.T) {
+	bs := store.NewMemoryStore()
+	s := testStream(t, 100)
+	_ = bs.Put(s[1].HashHex(), s[1])
+
+	client := NewClient(testServer(t, NewServer(bs, 0, "")))
+
+	available, err := client.DownloadCheck(context.Background(), []string{s[0].HashHex(), s[1].HashHex()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if available[s[0].HashHex()] || !available[s[1].HashHex()] {
+		t.Errorf("wrong availability: %v", available)
+	}
+
+	blobs, err := client.Download(context.Background(), []string{s[0].HashHex(), s[1].HashHex()})
+	if err == nil {
+		t.Error("expected an error for the missing blob")
+	}
+	if !bytes.Equal(blobs[s[1].HashHex()], s[1]) {
+		t.Error("available blob should still be returned")
+	}
+	if _, ok := blobs[s[0].HashHex()]; ok {
+		t.Error("missing blob should not be returned")
+	}
+}
+
+func TestClient_Upload(t *testing.T) {
+	bs := store.NewMemoryStore()
+	client := NewClient(testServer(t, NewServer(bs, 0, ""))).Sessions(2)
+
+	s := testStream(t, 3*stream.MaxBlobSize)
+	err := client.Upload(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, b := range s {
+		if has, _ := bs.Has(b.HashHex()); !has {
+			t.Errorf("blob %d was not uploaded", i)
+		}
+	}
+
+	downloaded, err := client.DownloadStream(context.Background(), s[0].HashHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := downloaded.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	original, err := s.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("uploaded stream does not decode to the original data")
+	}
+}
@@ -1,26 +1,51 @@
 package blobex
 
 import (
+	"encoding/hex"
 	"fmt"
+	"io"
 	"net"
 
 	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/store"
+	"github.com/lbryio/lbry.go/v2/stream"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
 
+// error codes sent in the Error message of a response
+const (
+	ErrorCodeInvalidRequest   uint32 = 1
+	ErrorCodeBlobNotAvailable uint32 = 2
+	ErrorCodeBlobNotWanted    uint32 = 3
+	ErrorCodeInvalidBlob      uint32 = 4 // blob is too big, empty, or does not match its hash
+	ErrorCodeInternal         uint32 = 5
+)
+
 type Server struct {
+	store      store.BlobStore
 	pricePerKB uint64
+	address    string
+}
+
+// NewServer returns a server that serves blobs from the store and saves uploaded blobs into it. The price is charged
+// per started KB of blob data, and should be paid to the given address.
+func NewServer(blobStore store.BlobStore, pricePerKB uint64, address string) *Server {
+	return &Server{
+		store:      blobStore,
+		pricePerKB: pricePerKB,
+		address:    address,
+	}
 }
 
-func ListenAndServe(port int) (*grpc.Server, error) {
+func ListenAndServe(port int, s *Server) (*grpc.Server, error) {
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, errors.Prefix("failed to listen", err)
 	}
 	grpcServer := grpc.NewServer()
-	RegisterBlobExchangeServer(grpcServer, &Server{})
+	RegisterBlobExchangeServer(grpcServer, s)
 	// determine whether to use TLS
 	err = grpcServer.Serve(listener)
 	return grpcServer, err
@@ -32,18 +57,178 @@ func (s *Server) PriceCheck(ctx context.Context, r *PriceCheckRequest) (*PriceCh
 	}, nil
 }
 
-func (s *Server) DownloadCheck(context.Context, *HashesRequest) (*HashesResponse, error) {
-	return nil, nil
+// DownloadCheck reports which of the requested hashes are available for download
+func (s *Server) DownloadCheck(ctx context.Context, r *HashesRequest) (*HashesResponse, error) {
+	res := &HashesResponse{Hashes: make(map[string]bool)}
+	for _, hash := range r.GetHashes() {
+		if !store.ValidHash(hash) {
+			res.Error = newError(ErrorCodeInvalidRequest, "invalid hash %s", hash)
+			return res, nil
+		}
+		has, err := s.store.Has(hash)
+		if err != nil {
+			res.Error = newError(ErrorCodeInternal, "checking %s: %s", hash, err.Error())
+			return res, nil
+		}
+		res.Hashes[hash] = has
+	}
+	return res, nil
+}
+
+func (s *Server) Download(srv BlobExchange_DownloadServer) error {
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = srv.Send(s.download(req.GetHash()))
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (s *Server) download(hash string) *DownloadResponse {
+	res := &DownloadResponse{Hash: hash}
+
+	if !store.ValidHash(hash) {
+		res.Error = newError(ErrorCodeInvalidRequest, "invalid hash %s", hash)
+		return res
+	}
+
+	blob, err := s.store.Get(hash)
+	if err != nil {
+		res.Error = newError(ErrorCodeBlobNotAvailable, "blob not available")
+		return res
+	}
+
+	// never send a corrupted blob, the client would reject it anyway
+	if blob.HashHex() != hash {
+		res.Error = newError(ErrorCodeBlobNotAvailable, "blob not available")
+		return res
+	}
+
+	res.Blob = blob
+	res.Price = s.price(blob.Size())
+	res.Address = s.address
+	return res
+}
+
+// UploadCheck reports which of the hashes the server wants. If a hash is a stream that the server already has, any
+// content blobs of that stream that the server is missing are added to the response, even if they were not requested.
+func (s *Server) UploadCheck(ctx context.Context, r *HashesRequest) (*HashesResponse, error) {
+	res := &HashesResponse{Hashes: make(map[string]bool)}
+	for _, hash := range r.GetHashes() {
+		if !store.ValidHash(hash) {
+			res.Error = newError(ErrorCodeInvalidRequest, "invalid hash %s", hash)
+			return res, nil
+		}
+		has, err := s.store.Has(hash)
+		if err != nil {
+			res.Error = newError(ErrorCodeInternal, "checking %s: %s", hash, err.Error())
+			return res, nil
+		}
+		res.Hashes[hash] = !has
+		if !has {
+			continue
+		}
+
+		missing, err := s.missingStreamBlobs(hash)
+		if err != nil {
+			res.Error = newError(ErrorCodeInternal, "checking stream %s: %s", hash, err.Error())
+			return res, nil
+		}
+		for _, h := range missing {
+			res.Hashes[h] = true
+		}
+	}
+	return res, nil
+}
+
+// missingStreamBlobs returns the content blobs we don't have if the hash is an sd blob. If the hash is not an sd blob,
+// it returns nothing.
+func (s *Server) missingStreamBlobs(hash string) ([]string, error) {
+	blob, err := s.store.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(blob) == 0 || blob[0] != '{' {
+		return nil, nil // content blobs are encrypted, so they won't look like json
+	}
+
+	sd := &stream.SDBlob{}
+	if sd.FromBlob(blob) != nil {
+		return nil, nil // not an sd blob
+	}
+
+	var missing []string
+	for _, info := range sd.BlobInfos {
+		if info.Length == 0 {
+			continue // terminating blob
+		}
+		h := hex.EncodeToString(info.BlobHash)
+		has, err := s.store.Has(h)
+		if err != nil {
+			return nil, err
+		}
+		if !has {
+			missing = append(missing, h)
+		}
+	}
+	return missing, nil
+}
+
+func (s *Server) Upload(srv BlobExchange_UploadServer) error {
+	for {
+		req, err := srv.Recv()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		err = srv.Send(s.upload(req.GetHash(), req.GetBlob()))
+		if err != nil {
+			return err
+		}
+	}
 }
 
-func (s *Server) Download(BlobExchange_DownloadServer) error {
-	return nil
+func (s *Server) upload(hash string, blob stream.Blob) *UploadResponse {
+	res := &UploadResponse{Hash: hash}
+
+	if !store.ValidHash(hash) {
+		res.Error = newError(ErrorCodeInvalidRequest, "invalid hash %s", hash)
+		return res
+	}
+
+	err := blob.ValidForSend()
+	if err != nil {
+		res.Error = newError(ErrorCodeInvalidBlob, "%s", err.Error())
+		return res
+	}
+
+	if blob.HashHex() != hash {
+		res.Error = newError(ErrorCodeInvalidBlob, "blob hash does not match %s", hash)
+		return res
+	}
+
+	err = s.store.Put(hash, blob)
+	if err != nil {
+		res.Error = newError(ErrorCodeInternal, "storing blob: %s", err.Error())
+	}
+	return res
 }
 
-func (s *Server) UploadCheck(context.Context, *HashesRequest) (*HashesResponse, error) {
-	return nil, nil
+// price returns the price of a blob, charging for every KB or part of a KB
+func (s *Server) price(size int) uint64 {
+	return s.pricePerKB * uint64((size+1023)/1024)
 }
 
-func (s *Server) Upload(BlobExchange_UploadServer) error {
-	return nil
+func newError(code uint32, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
 }
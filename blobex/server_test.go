@@ -0,0 +1,206 @@
+package blobex
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/lbryio/lbry.go/v2/store"
+	"github.com/lbryio/lbry.go/v2/stream"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testServer starts a server on an in-memory listener and returns a connection to it
+func testServer(t *testing.T, s *Server) *grpc.ClientConn {
+	listener := bufconn.Listen(1024 * 1024)
+	grpcServer := grpc.NewServer()
+	RegisterBlobExchangeServer(grpcServer, s)
+	go func() { _ = grpcServer.Serve(listener) }()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		_ = conn.Close()
+		grpcServer.Stop()
+	})
+	return conn
+}
+
+// testStream makes a stream with a few content blobs and returns its blobs, sd blob first
+func testStream(t *testing.T, size int) stream.Stream {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := stream.New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestServer_PriceCheck(t *testing.T) {
+	client := NewBlobExchangeClient(testServer(t, NewServer(store.NewMemoryStore(), 7, "bAddress")))
+
+	res, err := client.PriceCheck(context.Background(), &PriceCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetDeweysPerKB() != 7 {
+		t.Errorf("expected price of 7, got %d", res.GetDeweysPerKB())
+	}
+}
+
+func TestServer_Download(t *testing.T) {
+	bs := store.NewMemoryStore()
+	blob := testStream(t, 3000)[1]
+	_ = bs.Put(blob.HashHex(), blob)
+
+	client := NewBlobExchangeClient(testServer(t, NewServer(bs, 10, "bAddress")))
+
+	missing := stream.Blob("not stored").HashHex()
+	check, err := client.DownloadCheck(context.Background(), &HashesRequest{Hashes: []string{blob.HashHex(), missing}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if check.GetError() != nil {
+		t.Fatal(check.GetError().GetMessage())
+	}
+	if !check.GetHashes()[blob.HashHex()] {
+		t.Error("stored blob should be available")
+	}
+	if check.GetHashes()[missing] {
+		t.Error("missing blob should not be available")
+	}
+
+	dl, err := client.Download(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{blob.HashHex(), missing} {
+		err = dl.Send(&DownloadRequest{Hash: h})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res, err := dl.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetError() != nil {
+		t.Fatal(res.GetError().GetMessage())
+	}
+	if stream.Blob(res.GetBlob()).HashHex() != blob.HashHex() {
+		t.Error("downloaded blob does not match")
+	}
+	if res.GetPrice() != 10*3 { // 3008 bytes of ciphertext is 3 started KBs
+		t.Errorf("expected price of 30, got %d", res.GetPrice())
+	}
+	if res.GetAddress() != "bAddress" {
+		t.Errorf("expected payment address bAddress, got %s", res.GetAddress())
+	}
+
+	res, err = dl.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetHash() != missing || res.GetError().GetCode() != ErrorCodeBlobNotAvailable {
+		t.Errorf("expected blob not available error for missing blob, got %v", res)
+	}
+	_ = dl.CloseSend()
+}
+
+func TestServer_Upload(t *testing.T) {
+	bs := store.NewMemoryStore()
+	client := NewBlobExchangeClient(testServer(t, NewServer(bs, 0, "")))
+
+	blob := testStream(t, 3000)[1]
+
+	up, err := client.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = up.Send(&UploadRequest{Hash: blob.HashHex(), Blob: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := up.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetError() != nil {
+		t.Fatal(res.GetError().GetMessage())
+	}
+	if has, _ := bs.Has(blob.HashHex()); !has {
+		t.Error("uploaded blob was not stored")
+	}
+
+	// blob that does not match its hash
+	wrongHash := hex.EncodeToString(make([]byte, stream.BlobHashSize))
+	err = up.Send(&UploadRequest{Hash: wrongHash, Blob: blob})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = up.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetError().GetCode() != ErrorCodeInvalidBlob {
+		t.Errorf("expected invalid blob error, got %v", res.GetError())
+	}
+	if has, _ := bs.Has(wrongHash); has {
+		t.Error("blob with wrong hash should not be stored")
+	}
+	_ = up.CloseSend()
+}
+
+func TestServer_UploadCheckStream(t *testing.T) {
+	bs := store.NewMemoryStore()
+	s := testStream(t, 3*stream.MaxBlobSize)
+
+	// the server has the sd blob and the first content blob
+	_ = bs.Put(s[0].HashHex(), s[0])
+	_ = bs.Put(s[1].HashHex(), s[1])
+
+	client := NewBlobExchangeClient(testServer(t, NewServer(bs, 0, "")))
+
+	other := testStream(t, 100)[1]
+	res, err := client.UploadCheck(context.Background(), &HashesRequest{Hashes: []string{s[0].HashHex(), other.HashHex()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.GetError() != nil {
+		t.Fatal(res.GetError().GetMessage())
+	}
+
+	hashes := res.GetHashes()
+	if wanted, ok := hashes[s[0].HashHex()]; !ok || wanted {
+		t.Error("server should not want the sd blob it already has")
+	}
+	if !hashes[other.HashHex()] {
+		t.Error("server should want a blob it does not have")
+	}
+	if _, ok := hashes[s[1].HashHex()]; ok {
+		t.Error("server should not ask for a content blob it already has")
+	}
+	for _, b := range s[2:] {
+		if !hashes[b.HashHex()] {
+			t.Errorf("server should ask for missing content blob %s", b.HashHex())
+		}
+	}
+}
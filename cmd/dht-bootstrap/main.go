@@ -0,0 +1,71 @@
+// Command dht-bootstrap runs a DHT seed node. New nodes ping it and ask it for contacts when they join the network.
+package main
+
+import (
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func main() {
+	address := flag.String("address", "0.0.0.0:4444", "UDP address to listen on")
+	nodeID := flag.String("id", "", "hex node ID. a random ID is used if it's not set")
+	peersFile := flag.String("peers-file", "", "file to save known nodes to and load them from on startup")
+	maxPeers := flag.Int("max-peers", 10000, "the most nodes to keep. 0 means no limit")
+	status := flag.String("status", "", "address to serve the HTTP status endpoint on, e.g. 127.0.0.1:4445")
+	initialPing := flag.Duration("initial-ping", 10*time.Second, "how long to wait before pinging a new node")
+	checkInterval := flag.Duration("check-interval", 15*time.Minute, "how long a node can go unseen before it is pinged")
+	saveInterval := flag.Duration("save-interval", 5*time.Minute, "how often to save known nodes to the peers file")
+	debug := flag.Bool("debug", false, "log debug messages")
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
+
+	id := bits.Rand()
+	if *nodeID != "" {
+		var err error
+		id, err = bits.FromHex(*nodeID)
+		if err != nil {
+			log.Fatalf("invalid node ID: %s", err)
+		}
+	}
+
+	conf := dht.NewStandardConfig()
+	conf.Address = *address
+	b := dht.NewBootstrapNodeWithConfig(id, dht.BootstrapConfig{
+		InitialPingInterval: *initialPing,
+		CheckInterval:       *checkInterval,
+		MaxPeers:            *maxPeers,
+		PeersFile:           *peersFile,
+		SaveInterval:        *saveInterval,
+		StatusAddress:       *status,
+		Node:                conf,
+	})
+
+	listener, err := net.ListenPacket(dht.Network, *address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = b.Connect(listener.(*net.UDPConn))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("bootstrap node %s listening on %s", id.HexShort(), *address)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+
+	log.Info("shutting down")
+	b.Shutdown()
+}
@@ -0,0 +1,199 @@
+package dht
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+// AnnounceAction is the stage of an announce that an AnnounceEvent describes
+type AnnounceAction string
+
+const (
+	AnnounceStarted  AnnounceAction = "started"
+	AnnounceFinished AnnounceAction = "finished"
+)
+
+// announceHistoryLength is how many finished announces are remembered for each hash
+const announceHistoryLength = 5
+
+// AnnounceEvent describes the start or the end of an announce
+type AnnounceEvent struct {
+	Hash   bits.Bitmap
+	Action AnnounceAction
+	// when the event happened
+	Time time.Time
+	// the error that ended the announce. only set when the announce is finished
+	Err error
+	// the number of nodes, including this one, that stored the hash. only set when the announce is finished
+	StoredTo int
+	// the outcome of the store sent to each contact. only set when the announce is finished
+	Stores []StoreResult
+	// how long the announce took. only set when the announce is finished
+	Duration time.Duration
+}
+
+// DropPolicy decides which event is lost when a subscriber's buffer is full
+type DropPolicy int
+
+const (
+	// DropNewest drops the event that does not fit in the buffer
+	DropNewest DropPolicy = iota
+	// DropOldest drops the oldest event in the buffer to make room for the new one
+	DropOldest
+)
+
+// AnnounceSubscription receives announce events. The announcer never waits for a subscriber. If the buffer is full,
+// an event is dropped according to the drop policy.
+type AnnounceSubscription struct {
+	// C receives the events. It is closed when the subscription is closed or the DHT shuts down
+	C <-chan AnnounceEvent
+
+	ch      chan AnnounceEvent
+	policy  DropPolicy
+	events  *announceEvents
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+// Dropped returns the number of events that were dropped because the buffer was full
+func (s *AnnounceSubscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Close stops the subscription and closes C
+func (s *AnnounceSubscription) Close() {
+	s.events.unsubscribe(s)
+}
+
+func (s *AnnounceSubscription) send(e AnnounceEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.ch <- e:
+		return
+	default:
+	}
+
+	s.dropped++
+	if s.policy == DropOldest {
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *AnnounceSubscription) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+	}
+}
+
+// announceEvents sends announce events to subscribers and keeps the recent history of each hash
+type announceEvents struct {
+	mu          sync.RWMutex
+	subscribers map[*AnnounceSubscription]bool
+	history     map[bits.Bitmap][]AnnounceEvent
+	closed      bool
+}
+
+func newAnnounceEvents() *announceEvents {
+	return &announceEvents{
+		subscribers: make(map[*AnnounceSubscription]bool),
+		history:     make(map[bits.Bitmap][]AnnounceEvent),
+	}
+}
+
+func (a *announceEvents) subscribe(buffer int, policy DropPolicy) *AnnounceSubscription {
+	if buffer < 1 {
+		buffer = 1
+	}
+	ch := make(chan AnnounceEvent, buffer)
+	s := &AnnounceSubscription{C: ch, ch: ch, policy: policy, events: a}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		s.close()
+	} else {
+		a.subscribers[s] = true
+	}
+	return s
+}
+
+func (a *announceEvents) unsubscribe(s *AnnounceSubscription) {
+	a.mu.Lock()
+	delete(a.subscribers, s)
+	a.mu.Unlock()
+	s.close()
+}
+
+// publish sends the event to every subscriber, and records it in the history if the announce is finished
+func (a *announceEvents) publish(e AnnounceEvent) {
+	a.mu.Lock()
+	if e.Action == AnnounceFinished {
+		h := append(a.history[e.Hash], e)
+		if len(h) > announceHistoryLength {
+			h = append([]AnnounceEvent(nil), h[len(h)-announceHistoryLength:]...)
+		}
+		a.history[e.Hash] = h
+	}
+	a.mu.Unlock()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for s := range a.subscribers {
+		s.send(e)
+	}
+}
+
+// forget removes the history of a hash
+func (a *announceEvents) forget(hash bits.Bitmap) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.history, hash)
+}
+
+func (a *announceEvents) recent(hash bits.Bitmap) []AnnounceEvent {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]AnnounceEvent(nil), a.history[hash]...)
+}
+
+// close closes every subscription. later subscriptions are closed right away
+func (a *announceEvents) close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.closed = true
+	for s := range a.subscribers {
+		s.close()
+		delete(a.subscribers, s)
+	}
+}
+
+// SubscribeAnnounces returns a subscription to the start and finish events of every announce. buffer is the number
+// of events that can wait to be read before events are dropped.
+func (dht *DHT) SubscribeAnnounces(buffer int, policy DropPolicy) *AnnounceSubscription {
+	return dht.announceEvents.subscribe(buffer, policy)
+}
+
+// AnnounceHistory returns the most recent finished announces of the hash, oldest first
+func (dht *DHT) AnnounceHistory(hash bits.Bitmap) []AnnounceEvent {
+	return dht.announceEvents.recent(hash)
+}
@@ -0,0 +1,170 @@
+package dht
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+func TestAnnounceEvents_DropPolicy(t *testing.T) {
+	a := newAnnounceEvents()
+	newest := a.subscribe(2, DropNewest)
+	oldest := a.subscribe(2, DropOldest)
+
+	hashes := []bits.Bitmap{bits.Rand(), bits.Rand(), bits.Rand()}
+	for _, h := range hashes {
+		a.publish(AnnounceEvent{Hash: h, Action: AnnounceStarted})
+	}
+
+	if newest.Dropped() != 1 || oldest.Dropped() != 1 {
+		t.Errorf("expected 1 dropped event for each subscriber, got %d and %d", newest.Dropped(), oldest.Dropped())
+	}
+
+	expect := func(s *AnnounceSubscription, expected ...bits.Bitmap) {
+		t.Helper()
+		for _, h := range expected {
+			e := <-s.C
+			if !e.Hash.Equals(h) {
+				t.Errorf("expected event for %s, got %s", h.HexShort(), e.Hash.HexShort())
+			}
+		}
+	}
+	expect(newest, hashes[0], hashes[1])
+	expect(oldest, hashes[1], hashes[2])
+
+	newest.Close()
+	if _, ok := <-newest.C; ok {
+		t.Error("closed subscription should have a closed channel")
+	}
+	a.publish(AnnounceEvent{Hash: hashes[0], Action: AnnounceStarted}) // must not panic
+	expect(oldest, hashes[0])
+
+	a.close()
+	if _, ok := <-oldest.C; ok {
+		t.Error("subscriptions should be closed when the dht shuts down")
+	}
+	if _, ok := <-a.subscribe(1, DropNewest).C; ok {
+		t.Error("subscribing after shutdown should return a closed subscription")
+	}
+}
+
+func TestAnnounceEvents_History(t *testing.T) {
+	a := newAnnounceEvents()
+	hash := bits.Rand()
+
+	a.publish(AnnounceEvent{Hash: hash, Action: AnnounceStarted})
+	if len(a.recent(hash)) != 0 {
+		t.Error("started announces should not be in the history")
+	}
+
+	failed := errors.New("announce failed")
+	for i := 0; i < announceHistoryLength+2; i++ {
+		e := AnnounceEvent{Hash: hash, Action: AnnounceFinished, StoredTo: i}
+		if i == announceHistoryLength+1 {
+			e.Err = failed
+		}
+		a.publish(e)
+	}
+
+	history := a.recent(hash)
+	if len(history) != announceHistoryLength {
+		t.Fatalf("expected %d events in history, got %d", announceHistoryLength, len(history))
+	}
+	if history[0].StoredTo != 2 {
+		t.Errorf("expected the oldest events to be forgotten, first event has StoredTo %d", history[0].StoredTo)
+	}
+	if history[len(history)-1].Err != failed {
+		t.Error("expected the last event to have the error")
+	}
+
+	a.forget(hash)
+	if len(a.recent(hash)) != 0 {
+		t.Error("history should be empty after forgetting the hash")
+	}
+}
+
+func TestDHT_SubscribeAnnounces(t *testing.T) {
+	bs, dhts := TestingCreateNetwork(t, 2, true, false)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	d := dhts[1]
+	sub := d.SubscribeAnnounces(10, DropNewest)
+	defer sub.Close()
+
+	hash := bits.Rand()
+	d.Add(hash)
+
+	var events []AnnounceEvent
+	timeout := time.After(20 * time.Second)
+	for len(events) < 2 {
+		select {
+		case e := <-sub.C:
+			events = append(events, e)
+		case <-timeout:
+			t.Fatalf("timed out waiting for announce events, got %d", len(events))
+		}
+	}
+
+	if events[0].Action != AnnounceStarted || events[1].Action != AnnounceFinished {
+		t.Fatalf("expected started and finished events, got %s and %s", events[0].Action, events[1].Action)
+	}
+	for _, e := range events {
+		if !e.Hash.Equals(hash) {
+			t.Errorf("event is for %s, expected %s", e.Hash.HexShort(), hash.HexShort())
+		}
+	}
+
+	finished := events[1]
+	if finished.Err != nil {
+		t.Errorf("announce failed: %s", finished.Err)
+	}
+	if finished.StoredTo < 2 {
+		t.Errorf("expected the hash to be stored on this node and the other one, got %d", finished.StoredTo)
+	}
+	if finished.Duration <= 0 {
+		t.Error("expected the announce to take some time")
+	}
+
+	history := d.AnnounceHistory(hash)
+	if len(history) != 1 || history[0].StoredTo != finished.StoredTo {
+		t.Errorf("expected the finished announce in the history, got %+v", history)
+	}
+}
+
+func TestDHT_AnnounceNotificationCh(t *testing.T) {
+	conf := NewStandardConfig()
+	conf.AnnounceNotificationCh = make(chan announceNotification)
+
+	bs, dhts := TestingCreateNetworkWithConfig(t, 2, true, false, conf)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	hash := bits.Rand()
+	dhts[1].Add(hash)
+
+	timeout := time.After(20 * time.Second)
+	for _, action := range []string{announceStarted, announceFinishd} {
+		select {
+		case n := <-conf.AnnounceNotificationCh:
+			if n.action != action || !n.hash.Equals(hash) {
+				t.Errorf("expected %s notification for %s, got %s for %s", action, hash.HexShort(), n.action, n.hash.HexShort())
+			}
+			if n.err != nil {
+				t.Errorf("announce failed: %s", n.err)
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %s notification", action)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package dht
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+// AnnounceStatus describes the state of the announce queue
+type AnnounceStatus struct {
+	// the number of hashes being announced
+	Hashes int
+	// hashes that have not been announced yet
+	NeverAnnounced int
+	// hashes that have not been announced yet, or whose last announce is older than the reannounce time
+	Lagging int
+	// the time between announces that spreads them evenly over the reannounce time
+	Interval time.Duration
+	// when the next hash will be announced, if the rate limit allows it. zero if there are no hashes
+	NextAnnounce time.Time
+	// the most hashes that can be announced within the reannounce time at the announce rate
+	Capacity int
+}
+
+// announceRetryDelay is how long to wait before announcing a hash again after an announce failed or while it's still
+// running
+const announceRetryDelay = 1 * time.Minute
+
+// announceScheduler decides which hash to announce next, and when. Announces are spread evenly over the reannounce
+// time, instead of bunching up when a lot of hashes are added at once. To do this, the hash that was announced least
+// recently is announced early if the ideal time between announces (reannounce time / number of hashes) has passed.
+// Hashes that were never announced come first, followed by the ones that are most overdue. A hash whose announce
+// failed is retried after a short delay.
+type announceScheduler struct {
+	reannounceTime time.Duration
+	retryDelay     time.Duration
+	capacity       int
+
+	mu     sync.RWMutex
+	hashes map[bits.Bitmap]*scheduledHash
+	queue  scheduleQueue
+	// when a hash was last handed out to be announced
+	lastAnnounce time.Time
+}
+
+type scheduledHash struct {
+	hash bits.Bitmap
+	// when the hash was last announced successfully. zero if the hash has never been announced
+	lastAnnounce time.Time
+	// when the hash should be announced next. zero if it should be announced right away
+	due time.Time
+	// true if an announce was started and has not succeeded yet. such hashes are not announced early
+	retrying bool
+	index    int
+}
+
+func newAnnounceScheduler(reannounceTime time.Duration, announceRate int) *announceScheduler {
+	retryDelay := announceRetryDelay
+	if reannounceTime < retryDelay {
+		retryDelay = reannounceTime
+	}
+	return &announceScheduler{
+		reannounceTime: reannounceTime,
+		retryDelay:     retryDelay,
+		capacity:       announceRate * int(reannounceTime.Seconds()),
+		hashes:         make(map[bits.Bitmap]*scheduledHash),
+	}
+}
+
+// Add adds a hash to the schedule. It returns false if the hash was already scheduled.
+func (s *announceScheduler) Add(hash bits.Bitmap) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.hashes[hash]; ok {
+		return false
+	}
+	sh := &scheduledHash{hash: hash}
+	s.hashes[hash] = sh
+	heap.Push(&s.queue, sh)
+	return true
+}
+
+// Remove removes a hash from the schedule. It returns false if the hash was not scheduled.
+func (s *announceScheduler) Remove(hash bits.Bitmap) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sh, ok := s.hashes[hash]
+	if !ok {
+		return false
+	}
+	delete(s.hashes, hash)
+	heap.Remove(&s.queue, sh.index)
+	return true
+}
+
+// Len returns the number of scheduled hashes
+func (s *announceScheduler) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.hashes)
+}
+
+// Hashes returns the scheduled hashes
+func (s *announceScheduler) Hashes() []bits.Bitmap {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	hashes := make([]bits.Bitmap, 0, len(s.hashes))
+	for h := range s.hashes {
+		hashes = append(hashes, h)
+	}
+	return hashes
+}
+
+// LastAnnounced returns when each scheduled hash was last announced. the time is zero for hashes that were never
+// announced
+func (s *announceScheduler) LastAnnounced() map[bits.Bitmap]time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	times := make(map[bits.Bitmap]time.Time, len(s.hashes))
+	for h, sh := range s.hashes {
+		times[h] = sh.lastAnnounce
+	}
+	return times
+}
+
+// Next returns the hash that should be announced next and how long to wait before announcing it. ok is false if there
+// are no hashes.
+func (s *announceScheduler) Next(now time.Time) (next scheduledHash, wait time.Duration, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.queue) == 0 {
+		return scheduledHash{}, 0, false
+	}
+	next = *s.queue[0]
+	return next, s.nextAnnounce(now).Sub(now), true
+}
+
+// Started records that an announce of the hash started at the given time. Until the announce succeeds, the hash is
+// scheduled to be retried after the retry delay.
+func (s *announceScheduler) Started(hash bits.Bitmap, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAnnounce = at
+	if sh, ok := s.hashes[hash]; ok {
+		sh.due = at.Add(s.retryDelay)
+		sh.retrying = true
+		heap.Fix(&s.queue, sh.index)
+	}
+}
+
+// Announced records that the hash was announced successfully by an announce that started at the given time
+func (s *announceScheduler) Announced(hash bits.Bitmap, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if at.After(s.lastAnnounce) {
+		s.lastAnnounce = at
+	}
+	if sh, ok := s.hashes[hash]; ok {
+		sh.lastAnnounce = at
+		sh.due = at.Add(s.reannounceTime)
+		sh.retrying = false
+		heap.Fix(&s.queue, sh.index)
+	}
+}
+
+// Status returns the state of the schedule
+func (s *announceScheduler) Status(now time.Time) AnnounceStatus {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	status := AnnounceStatus{
+		Hashes:   len(s.hashes),
+		Interval: s.interval(),
+		Capacity: s.capacity,
+	}
+	for _, sh := range s.queue {
+		if sh.lastAnnounce.IsZero() {
+			status.NeverAnnounced++
+			status.Lagging++
+		} else if s.isOverdue(sh, now) {
+			status.Lagging++
+		}
+	}
+	if len(s.queue) > 0 {
+		status.NextAnnounce = s.nextAnnounce(now)
+	}
+	return status
+}
+
+// interval returns the time between announces that spreads them evenly over the reannounce time
+func (s *announceScheduler) interval() time.Duration {
+	if len(s.hashes) == 0 {
+		return s.reannounceTime
+	}
+	return s.reannounceTime / time.Duration(len(s.hashes))
+}
+
+// nextAnnounce returns when the first hash in the queue should be announced. it must be called with the lock held
+// and a non-empty queue
+func (s *announceScheduler) nextAnnounce(now time.Time) time.Time {
+	head := s.queue[0]
+	if head.due.IsZero() || s.lastAnnounce.IsZero() {
+		return now
+	}
+
+	// announce early if it's been more than the ideal interval since the last announce, but never later than the
+	// hash is due. hashes that are waiting to be retried are not announced early
+	next := head.due
+	if early := s.lastAnnounce.Add(s.interval()); !head.retrying && early.Before(next) {
+		next = early
+	}
+	if next.Before(now) {
+		return now
+	}
+	return next
+}
+
+func (s *announceScheduler) isOverdue(sh *scheduledHash, now time.Time) bool {
+	return !sh.lastAnnounce.IsZero() && now.Sub(sh.lastAnnounce) > s.reannounceTime
+}
+
+// lag returns how far past its reannounce time the hash is. it is zero for hashes that are not overdue and hashes
+// that have never been announced
+func (s *announceScheduler) lag(sh scheduledHash, now time.Time) time.Duration {
+	if sh.lastAnnounce.IsZero() {
+		return 0
+	}
+	if lag := now.Sub(sh.lastAnnounce) - s.reannounceTime; lag > 0 {
+		return lag
+	}
+	return 0
+}
+
+// scheduleQueue is a min-heap of hashes, ordered by when they are due. hashes that have never been announced sort
+// first
+type scheduleQueue []*scheduledHash
+
+func (q scheduleQueue) Len() int { return len(q) }
+
+func (q scheduleQueue) Less(i, j int) bool {
+	return q[i].due.Before(q[j].due)
+}
+
+func (q scheduleQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *scheduleQueue) Push(x interface{}) {
+	sh := x.(*scheduledHash)
+	sh.index = len(*q)
+	*q = append(*q, sh)
+}
+
+func (q *scheduleQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	sh := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return sh
+}
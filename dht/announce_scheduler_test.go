@@ -0,0 +1,176 @@
+package dht
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+func TestAnnounceScheduler_Order(t *testing.T) {
+	s := newAnnounceScheduler(time.Hour, 10)
+	now := time.Now()
+
+	old, older, fresh := bits.Rand(), bits.Rand(), bits.Rand()
+	for _, h := range []bits.Bitmap{old, older} {
+		s.Add(h)
+	}
+	s.Announced(older, now.Add(-3*time.Hour))
+	s.Announced(old, now.Add(-2*time.Hour))
+
+	if s.Add(old) {
+		t.Error("adding a hash twice should return false")
+	}
+	s.Add(fresh)
+
+	// never-announced hashes go first, then the most overdue ones
+	for _, expected := range []bits.Bitmap{fresh, older, old} {
+		next, wait, ok := s.Next(now)
+		if !ok {
+			t.Fatal("expected a hash to announce")
+		}
+		if !next.hash.Equals(expected) {
+			t.Errorf("expected %s to be announced next, got %s", expected.HexShort(), next.hash.HexShort())
+		}
+		if wait > 0 {
+			t.Errorf("expected %s to be announced right away, got wait of %s", expected.HexShort(), wait)
+		}
+		s.Announced(next.hash, now)
+		now = now.Add(time.Second)
+	}
+
+	if !s.Remove(old) || s.Remove(old) {
+		t.Error("remove should return true only if the hash was scheduled")
+	}
+	if s.Len() != 2 {
+		t.Errorf("expected 2 hashes, got %d", s.Len())
+	}
+}
+
+func TestAnnounceScheduler_Spread(t *testing.T) {
+	reannounceTime := 40 * time.Minute
+	numHashes := 8
+	interval := reannounceTime / time.Duration(numHashes)
+
+	s := newAnnounceScheduler(reannounceTime, 10)
+	for i := 0; i < numHashes; i++ {
+		s.Add(bits.Rand())
+	}
+
+	now := time.Now()
+	lastAnnounced := make(map[bits.Bitmap]time.Time)
+	var prev time.Time
+
+	for i := 0; i < 5*numHashes; i++ {
+		next, wait, ok := s.Next(now)
+		if !ok {
+			t.Fatal("expected a hash to announce")
+		}
+		now = now.Add(wait)
+
+		if i >= numHashes {
+			// after the first round, announces are spread evenly instead of waiting for the reannounce time
+			if gap := now.Sub(prev); gap != interval {
+				t.Fatalf("announce %d: expected %s between announces, got %s", i, interval, gap)
+			}
+		}
+		if last, ok := lastAnnounced[next.hash]; ok && now.Sub(last) > reannounceTime {
+			t.Fatalf("announce %d: hash was announced %s after its last announce", i, now.Sub(last))
+		}
+
+		s.Announced(next.hash, now)
+		lastAnnounced[next.hash] = now
+		prev = now
+	}
+}
+
+func TestAnnounceScheduler_Retry(t *testing.T) {
+	s := newAnnounceScheduler(time.Hour, 10)
+	now := time.Now()
+
+	failing, other := bits.Rand(), bits.Rand()
+	s.Add(failing)
+	s.Add(other)
+	s.Announced(other, now.Add(-50*time.Minute))
+
+	// the announce starts, but never succeeds
+	next, _, _ := s.Next(now)
+	if !next.hash.Equals(failing) {
+		t.Fatalf("expected the never-announced hash first, got %s", next.hash.HexShort())
+	}
+	s.Started(failing, now)
+	if !s.LastAnnounced()[failing].IsZero() {
+		t.Error("a hash should not be marked as announced when its announce starts")
+	}
+
+	// it's retried after the retry delay, and not announced early in the meantime
+	next, wait, _ := s.Next(now)
+	if !next.hash.Equals(failing) || wait != announceRetryDelay {
+		t.Errorf("expected a retry in %s, got %s in %s", announceRetryDelay, next.hash.HexShort(), wait)
+	}
+
+	now = now.Add(announceRetryDelay)
+	s.Started(failing, now)
+	s.Announced(failing, now)
+	if !s.LastAnnounced()[failing].Equal(now) {
+		t.Error("a hash should be marked as announced when its announce succeeds")
+	}
+	next, _, _ = s.Next(now)
+	if !next.hash.Equals(other) {
+		t.Errorf("expected the other hash next, got %s", next.hash.HexShort())
+	}
+}
+
+func TestAnnounceScheduler_Status(t *testing.T) {
+	s := newAnnounceScheduler(time.Hour, 2)
+	now := time.Now()
+
+	status := s.Status(now)
+	if status.Hashes != 0 || !status.NextAnnounce.IsZero() {
+		t.Errorf("expected an empty status, got %+v", status)
+	}
+	if status.Capacity != 7200 {
+		t.Errorf("expected capacity of 7200, got %d", status.Capacity)
+	}
+
+	overdue, current, never := bits.Rand(), bits.Rand(), bits.Rand()
+	s.Add(overdue)
+	s.Add(current)
+	s.Add(never)
+	s.Announced(overdue, now.Add(-2*time.Hour))
+	s.Announced(current, now.Add(-time.Minute))
+
+	status = s.Status(now)
+	if status.Hashes != 3 {
+		t.Errorf("expected 3 hashes, got %d", status.Hashes)
+	}
+	if status.NeverAnnounced != 1 {
+		t.Errorf("expected 1 never-announced hash, got %d", status.NeverAnnounced)
+	}
+	if status.Lagging != 2 {
+		t.Errorf("expected 2 lagging hashes, got %d", status.Lagging)
+	}
+	if status.Interval != 20*time.Minute {
+		t.Errorf("expected interval of 20m, got %s", status.Interval)
+	}
+	if !status.NextAnnounce.Equal(now) {
+		t.Errorf("expected next announce now, got %s", status.NextAnnounce)
+	}
+
+	next, _, _ := s.Next(now)
+	s.Announced(next.hash, now)
+	next, _, _ = s.Next(now)
+	if lag := s.lag(next, now); lag != time.Hour {
+		t.Errorf("expected overdue hash to lag by 1h, got %s", lag)
+	}
+	s.Announced(next.hash, now)
+
+	// everything is announced, so the next one is spread out by the interval
+	status = s.Status(now)
+	if status.Lagging != 0 {
+		t.Errorf("expected no lagging hashes, got %d", status.Lagging)
+	}
+	if expected := now.Add(20 * time.Minute); !status.NextAnnounce.Equal(expected) {
+		t.Errorf("expected next announce at %s, got %s", expected, status.NextAnnounce)
+	}
+}
@@ -1,6 +1,7 @@
 package bits
 
 import (
+	"crypto/rand"
 	"math/big"
 
 	"github.com/lbryio/lbry.go/v2/extras/errors"
@@ -63,3 +64,13 @@ func (r Range) IntervalSize() *big.Int {
 func (r Range) Contains(b Bitmap) bool {
 	return r.Start.Cmp(b) <= 0 && r.End.Cmp(b) >= 0
 }
+
+// Rand returns a cryptographically random bitmap in the range, including the start and end
+func (r Range) Rand() Bitmap {
+	size := r.IntervalSize()
+	n, err := rand.Int(rand.Reader, size.Add(size, big.NewInt(1)))
+	if err != nil {
+		panic(err)
+	}
+	return FromBigP(n.Add(n, r.Start.Big()))
+}
@@ -46,3 +46,22 @@ func TestRange_IntervalP(t *testing.T) {
 		lastEnd = ival.End
 	}
 }
+
+func TestRange_Rand(t *testing.T) {
+	r := Range{Start: FromShortHexP("10"), End: FromShortHexP("12")}
+	seen := make(map[Bitmap]bool)
+	for i := 0; i < 100; i++ {
+		b := r.Rand()
+		if !r.Contains(b) {
+			t.Fatalf("%s is not in the range", b.Hex())
+		}
+		seen[b] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("expected all 3 bitmaps in the range, got %d", len(seen))
+	}
+
+	if b := MaxRange().Rand(); !MaxRange().Contains(b) {
+		t.Errorf("%s is not in the max range", b.Hex())
+	}
+}
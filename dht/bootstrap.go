@@ -1,41 +1,151 @@
 package dht
 
 import (
+	"context"
+	"encoding/json"
 	"math/rand"
 	"net"
+	"net/http"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
 )
 
 const (
 	bootstrapDefaultRefreshDuration = 15 * time.Minute
+	bootstrapDefaultSaveInterval    = 5 * time.Minute
 )
 
-// BootstrapNode is a configured node setup for testing.
+// BootstrapConfig configures a BootstrapNode
+type BootstrapConfig struct {
+	// how long to wait before pinging a node that sent a request but is not known yet
+	InitialPingInterval time.Duration
+	// known nodes that have not been heard from in this long are pinged, and removed if they don't respond
+	CheckInterval time.Duration
+	// the most nodes to keep. when the list is full, the least recently seen node is evicted to make room for a new one.
+	// zero means no limit
+	MaxPeers int
+	// file that the known nodes are saved to, and loaded from when the node connects. empty means nodes are only kept
+	// in memory
+	PeersFile string
+	// how often the known nodes are saved to PeersFile. they are also saved on shutdown
+	SaveInterval time.Duration
+	// address to serve the HTTP status endpoint on, e.g. "127.0.0.1:4445". empty means no endpoint
+	StatusAddress string
+	// configuration of the underlying node. nil means the standard config
+	Node *Config
+}
+
+func (c *BootstrapConfig) setDefaults() {
+	if c.CheckInterval <= 0 {
+		c.CheckInterval = bootstrapDefaultRefreshDuration
+	}
+	if c.SaveInterval <= 0 {
+		c.SaveInterval = bootstrapDefaultSaveInterval
+	}
+	if c.Node == nil {
+		c.Node = NewStandardConfig()
+	}
+}
+
+// BootstrapStats are the counts a BootstrapNode exports on its status endpoint
+type BootstrapStats struct {
+	NodeID   string `json:"node_id"`
+	Peers    int    `json:"peers"`
+	MaxPeers int    `json:"max_peers"`
+	// the number of nodes that were added to the list, removed because they stopped responding, and evicted to make
+	// room for new nodes
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Evicted int `json:"evicted"`
+	// the number of requests received, by method. requests with an unknown method are counted as "other"
+	Requests  map[string]int `json:"requests"`
+	StartedAt time.Time      `json:"started_at"`
+	// when the known nodes were last saved. zero if they were never saved
+	LastSaved time.Time `json:"last_saved"`
+}
+
+// BootstrapNode is a seed node. It keeps a list of the nodes that are responding and answers findNode requests with a
+// random sample of them, so new nodes can join the network.
 type BootstrapNode struct {
 	Node
 
 	initialPingInterval time.Duration
 	checkInterval       time.Duration
+	maxPeers            int
+	peersFile           string
+	saveInterval        time.Duration
+	statusAddress       string
 
 	nlock   *sync.RWMutex
 	peers   map[bits.Bitmap]*peer
 	nodeIDs []bits.Bitmap // necessary for efficient random ID selection
+	stats   BootstrapStats
+
+	// request counts are updated on every packet, so they don't take nlock
+	requestCounts bootstrapRequestCounts
+}
+
+// bootstrapRequestCounts counts the requests received for each known method
+type bootstrapRequestCounts struct {
+	ping, store, findNode, findValue, other atomic.Int64
+}
+
+func (c *bootstrapRequestCounts) count(method string) {
+	switch method {
+	case pingMethod:
+		c.ping.Add(1)
+	case storeMethod:
+		c.store.Add(1)
+	case findNodeMethod:
+		c.findNode.Add(1)
+	case findValueMethod:
+		c.findValue.Add(1)
+	default:
+		c.other.Add(1)
+	}
+}
+
+func (c *bootstrapRequestCounts) snapshot() map[string]int {
+	return map[string]int{
+		pingMethod:      int(c.ping.Load()),
+		storeMethod:     int(c.store.Load()),
+		findNodeMethod:  int(c.findNode.Load()),
+		findValueMethod: int(c.findValue.Load()),
+		"other":         int(c.other.Load()),
+	}
 }
 
 // NewBootstrapNode returns a BootstrapNode pointer.
 func NewBootstrapNode(id bits.Bitmap, initialPingInterval, rePingInterval time.Duration) *BootstrapNode {
+	return NewBootstrapNodeWithConfig(id, BootstrapConfig{
+		InitialPingInterval: initialPingInterval,
+		CheckInterval:       rePingInterval,
+	})
+}
+
+// NewBootstrapNodeWithConfig returns a BootstrapNode that uses conf. Options that are not set get their default values.
+func NewBootstrapNodeWithConfig(id bits.Bitmap, conf BootstrapConfig) *BootstrapNode {
+	conf.setDefaults()
+
 	b := &BootstrapNode{
-		Node: *NewNode(id),
+		Node: *NewNodeWithConfig(id, conf.Node),
 
-		initialPingInterval: initialPingInterval,
-		checkInterval:       rePingInterval,
+		initialPingInterval: conf.InitialPingInterval,
+		checkInterval:       conf.CheckInterval,
+		maxPeers:            conf.MaxPeers,
+		peersFile:           conf.PeersFile,
+		saveInterval:        conf.SaveInterval,
+		statusAddress:       conf.StatusAddress,
 
 		nlock:   &sync.RWMutex{},
 		peers:   make(map[bits.Bitmap]*peer),
 		nodeIDs: make([]bits.Bitmap, 0),
+		stats:   BootstrapStats{NodeID: id.Hex(), MaxPeers: conf.MaxPeers},
 	}
 
 	b.requestHandler = b.handleRequest
@@ -50,28 +160,93 @@ func (b *BootstrapNode) Add(c Contact) {
 
 // Connect connects to the given connection and starts any background threads necessary
 func (b *BootstrapNode) Connect(conn UDPConn) error {
+	if b.peersFile != "" {
+		err := b.loadPeers()
+		if err != nil {
+			return err
+		}
+	}
+
+	var status net.Listener
+	if b.statusAddress != "" {
+		var err error
+		status, err = net.Listen("tcp", b.statusAddress)
+		if err != nil {
+			return errors.Prefix("listening for status requests", err)
+		}
+	}
+
 	err := b.Node.Connect(conn)
 	if err != nil {
+		if status != nil {
+			status.Close()
+		}
 		return err
 	}
 
+	b.nlock.Lock()
+	b.stats.StartedAt = time.Now()
+	b.nlock.Unlock()
+
 	log.Infof("[%s] bootstrap: node connected", b.id.HexShort())
 
+	b.grp.Add(1)
 	go func() {
-		t := time.NewTicker(b.checkInterval / 5)
+		defer b.grp.Done()
+		check := time.NewTicker(b.checkInterval / 5)
+		defer check.Stop()
+		save := time.NewTicker(b.saveInterval)
+		defer save.Stop()
 		for {
 			select {
-			case <-t.C:
+			case <-check.C:
 				b.check()
+			case <-save.C:
+				if b.peersFile != "" {
+					err := b.savePeers()
+					if err != nil {
+						log.Error(errors.Prefix("saving bootstrap peers", err))
+					}
+				}
 			case <-b.grp.Ch():
 				return
 			}
 		}
 	}()
 
+	if status != nil {
+		b.grp.Add(1)
+		go func() {
+			defer b.grp.Done()
+			b.serveStatus(status)
+		}()
+	}
+
 	return nil
 }
 
+// Shutdown shuts down the node and saves the known nodes to the peers file
+func (b *BootstrapNode) Shutdown() {
+	b.Node.Shutdown()
+	if b.peersFile != "" {
+		err := b.savePeers()
+		if err != nil {
+			log.Error(errors.Prefix("saving bootstrap peers", err))
+		}
+	}
+}
+
+// Stats returns the number of known nodes and other counts
+func (b *BootstrapNode) Stats() BootstrapStats {
+	b.nlock.RLock()
+	defer b.nlock.RUnlock()
+
+	s := b.stats
+	s.Peers = len(b.peers)
+	s.Requests = b.requestCounts.snapshot()
+	return s
+}
+
 // upsert adds the contact to the list, or updates the lastPinged time
 func (b *BootstrapNode) upsert(c Contact) {
 	b.nlock.Lock()
@@ -83,9 +258,28 @@ func (b *BootstrapNode) upsert(c Contact) {
 		return
 	}
 
+	b.add(c, time.Now())
+}
+
+// add adds a new contact to the list, evicting the least recently seen contact if the list is full. the lock must be
+// held
+func (b *BootstrapNode) add(c Contact, lastActivity time.Time) {
+	if b.maxPeers > 0 && len(b.peers) >= b.maxPeers {
+		var oldest *peer
+		for _, p := range b.peers {
+			if oldest == nil || p.LastActivity.Before(oldest.LastActivity) {
+				oldest = p
+			}
+		}
+		log.Debugf("[%s] bootstrap: evicting contact %s", b.id.HexShort(), oldest.Contact.ID.HexShort())
+		b.delete(oldest.Contact.ID)
+		b.stats.Evicted++
+	}
+
 	log.Debugf("[%s] bootstrap: adding new contact %s", b.id.HexShort(), c.ID.HexShort())
-	b.peers[c.ID] = &peer{c, b.id.Xor(c.ID), time.Now(), 0}
+	b.peers[c.ID] = &peer{c, b.id.Xor(c.ID), lastActivity, 0}
 	b.nodeIDs = append(b.nodeIDs, c.ID)
+	b.stats.Added++
 }
 
 // remove removes the contact from the list
@@ -99,9 +293,15 @@ func (b *BootstrapNode) remove(c Contact) {
 	}
 
 	log.Debugf("[%s] bootstrap: removing contact %s", b.id.HexShort(), c.ID.HexShort())
-	delete(b.peers, c.ID)
+	b.delete(c.ID)
+	b.stats.Removed++
+}
+
+// delete removes the id from the list. the lock must be held
+func (b *BootstrapNode) delete(id bits.Bitmap) {
+	delete(b.peers, id)
 	for i := range b.nodeIDs {
-		if b.nodeIDs[i].Equals(c.ID) {
+		if b.nodeIDs[i].Equals(id) {
 			b.nodeIDs = append(b.nodeIDs[:i], b.nodeIDs[i+1:]...)
 			break
 		}
@@ -159,8 +359,97 @@ func (b *BootstrapNode) check() {
 	}
 }
 
+// loadPeers adds the contacts saved in the peers file. they have not been seen since they were saved, so they are
+// pinged on the next check. a missing file is not an error.
+func (b *BootstrapNode) loadPeers() error {
+	data, err := os.ReadFile(b.peersFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.Err(err)
+	}
+
+	var saved rtSave
+	err = json.Unmarshal(data, &saved)
+	if err != nil {
+		return errors.Prefix("decoding peers file", err)
+	}
+	contacts, err := saved.contacts()
+	if err != nil {
+		return err
+	}
+
+	b.nlock.Lock()
+	defer b.nlock.Unlock()
+	for _, c := range contacts {
+		if _, exists := b.peers[c.ID]; !exists {
+			b.add(c, time.Time{})
+		}
+	}
+
+	log.Infof("[%s] bootstrap: loaded %d contacts from %s", b.id.HexShort(), len(contacts), b.peersFile)
+	return nil
+}
+
+// savePeers writes the known contacts to the peers file
+func (b *BootstrapNode) savePeers() error {
+	saved := rtSave{ID: b.id.Hex(), Contacts: []string{}}
+	b.nlock.RLock()
+	for _, id := range b.nodeIDs {
+		saved.add(b.peers[id].Contact)
+	}
+	b.nlock.RUnlock()
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return errors.Err(err)
+	}
+	err = writeFileAtomic(b.peersFile, data)
+	if err != nil {
+		return err
+	}
+
+	b.nlock.Lock()
+	b.stats.LastSaved = time.Now()
+	b.nlock.Unlock()
+	return nil
+}
+
+// serveStatus serves the stats as JSON until the node shuts down
+func (b *BootstrapNode) serveStatus(l net.Listener) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		err := json.NewEncoder(w).Encode(b.Stats())
+		if err != nil {
+			log.Error(errors.Prefix("writing bootstrap status", err))
+		}
+	})
+	server := &http.Server{Handler: mux}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		log.Infof("[%s] bootstrap: status endpoint listening on %s", b.id.HexShort(), l.Addr())
+		err := server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			log.Error(err)
+		}
+	}()
+
+	<-b.grp.Ch()
+	err := server.Shutdown(context.Background())
+	if err != nil {
+		log.Error(errors.Prefix("shutting down bootstrap status endpoint", err))
+	}
+	wg.Wait()
+}
+
 // handleRequest handles the requests received from udp.
 func (b *BootstrapNode) handleRequest(addr *net.UDPAddr, request Request) {
+	b.requestCounts.count(request.Method)
+
 	switch request.Method {
 	case pingMethod:
 		err := b.sendMessage(addr, Response{ID: request.ID, NodeID: b.id, Data: pingSuccessResponse})
@@ -176,7 +465,7 @@ func (b *BootstrapNode) handleRequest(addr *net.UDPAddr, request Request) {
 		err := b.sendMessage(addr, Response{
 			ID:       request.ID,
 			NodeID:   b.id,
-			Contacts: b.get(bucketSize),
+			Contacts: contactsForVersion(b.get(b.conf.BucketSize), request.ProtocolVersion),
 		})
 		if err != nil {
 			log.Error("error sending 'findnodemethod' response message - ", err)
@@ -189,7 +478,11 @@ func (b *BootstrapNode) handleRequest(addr *net.UDPAddr, request Request) {
 		b.nlock.RUnlock()
 		if !exists {
 			log.Debugf("[%s] bootstrap: queuing %s to ping", b.id.HexShort(), request.NodeID.HexShort())
-			<-time.After(b.initialPingInterval)
+			select {
+			case <-time.After(b.initialPingInterval):
+			case <-b.grp.Ch():
+				return
+			}
 			b.nlock.RLock()
 			_, exists = b.peers[request.NodeID]
 			b.nlock.RUnlock()
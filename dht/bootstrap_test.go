@@ -1,8 +1,12 @@
 package dht
 
 import (
+	"encoding/json"
 	"net"
+	"net/http"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
 )
@@ -22,3 +26,122 @@ func TestBootstrapPing(t *testing.T) {
 
 	b.Shutdown()
 }
+
+func TestBootstrap_MaxPeers(t *testing.T) {
+	b := NewBootstrapNodeWithConfig(bits.Rand(), BootstrapConfig{MaxPeers: 2})
+
+	first := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444}
+	second := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.5"), Port: 4444}
+	third := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.6"), Port: 4444}
+
+	b.Add(first)
+	b.Add(second)
+	b.Add(first) // first was seen more recently than second now
+	b.Add(third)
+
+	if _, ok := b.peers[second.ID]; ok {
+		t.Error("the least recently seen contact should have been evicted")
+	}
+	for _, c := range []Contact{first, third} {
+		if _, ok := b.peers[c.ID]; !ok {
+			t.Errorf("expected %s to be kept", c.ID.HexShort())
+		}
+	}
+	if len(b.nodeIDs) != 2 {
+		t.Errorf("expected 2 node IDs, got %d", len(b.nodeIDs))
+	}
+
+	stats := b.Stats()
+	if stats.Peers != 2 || stats.Added != 3 || stats.Evicted != 1 {
+		t.Errorf("expected 2 peers, 3 added and 1 evicted, got %d, %d and %d", stats.Peers, stats.Added, stats.Evicted)
+	}
+}
+
+func TestBootstrap_PeersFile(t *testing.T) {
+	conf := BootstrapConfig{PeersFile: filepath.Join(t.TempDir(), "peers.json")}
+	contacts := []Contact{
+		{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444},
+		{ID: bits.Rand(), IP: net.ParseIP("2001:db8::1"), Port: 4445},
+	}
+
+	b := NewBootstrapNodeWithConfig(bits.Rand(), conf)
+	err := b.Connect(newTestUDPConn("127.0.0.1:21217"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range contacts {
+		b.Add(c)
+	}
+	b.Shutdown()
+
+	b = NewBootstrapNodeWithConfig(bits.Rand(), conf)
+	err = b.Connect(newTestUDPConn("127.0.0.1:21217"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Shutdown()
+
+	if len(b.peers) != len(contacts) {
+		t.Fatalf("expected %d contacts after restarting, got %d", len(contacts), len(b.peers))
+	}
+	for _, c := range contacts {
+		p, ok := b.peers[c.ID]
+		if !ok {
+			t.Fatalf("contact %s was not loaded", c.ID.HexShort())
+		}
+		if !p.Contact.Equals(c, true) {
+			t.Errorf("expected %s, got %s", c.String(), p.Contact.String())
+		}
+		if p.ActiveInLast(b.checkInterval) {
+			t.Error("loaded contacts should be pinged on the next check")
+		}
+	}
+}
+
+func TestBootstrap_Status(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+	b := NewBootstrapNodeWithConfig(bits.Rand(), BootstrapConfig{
+		InitialPingInterval: time.Hour,
+		MaxPeers:            10,
+		StatusAddress:       "127.0.0.1:21312",
+	})
+	err := b.Connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Shutdown()
+
+	b.Add(Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444})
+	sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: pingMethod})
+	// made-up methods all share one counter, so they can't grow the stats
+	for _, method := range []string{"bogus", "alsoBogus"} {
+		b.handleRequest(conn.addr, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: method})
+	}
+
+	res, err := http.Get("http://127.0.0.1:21312/status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var stats BootstrapStats
+	err = json.NewDecoder(res.Body).Decode(&stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.NodeID != b.id.Hex() {
+		t.Errorf("expected node ID %s, got %s", b.id.Hex(), stats.NodeID)
+	}
+	if stats.Peers != 1 || stats.MaxPeers != 10 {
+		t.Errorf("expected 1 of 10 peers, got %d of %d", stats.Peers, stats.MaxPeers)
+	}
+	if stats.Requests[pingMethod] != 1 {
+		t.Errorf("expected 1 ping, got %d", stats.Requests[pingMethod])
+	}
+	if stats.Requests["other"] != 2 || len(stats.Requests) != 5 {
+		t.Errorf("expected 2 requests with unknown methods counted together, got %v", stats.Requests)
+	}
+	if stats.StartedAt.IsZero() {
+		t.Error("expected the start time to be set")
+	}
+}
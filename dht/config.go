@@ -8,39 +8,62 @@ import (
 )
 
 const (
-	Network         = "udp4"
+	Network         = "udp" // listens on IPv4 and IPv6 if the address is [::]:port
 	DefaultPort     = 4444
 	DefaultPeerPort = 3333
 
 	DefaultAnnounceRate   = 10               // send at most this many announces per second
 	DefaultReannounceTime = 50 * time.Minute // should be a bit less than hash expiration time
 
-	// TODO: all these constants should be defaults, and should be used to set values in the standard Config. then the code should use values in the config
-	// TODO: alternatively, have a global Config for constants. at least that way tests can modify the values
-	alpha           = 5             // this is the constant alpha in the spec
-	bucketSize      = 8             // this is the constant k in the spec
+	// these are the abuse protection limits in the standard config
+	DefaultRequestRate     = 50     // requests per second accepted from each IP
+	DefaultRequestBurst    = 200    // requests an IP can send at once before DefaultRequestRate applies
+	DefaultMaxPeersPerHash = 1000   // peers stored for one hash
+	DefaultMaxStoresPerIP  = 100000 // hash/peer pairs stored from one IP
+
+	// these are the defaults for the Kademlia parameters in Config
+	defaultAlpha                       = 5               // this is the constant alpha in the spec
+	defaultBucketSize                  = 8               // this is the constant k in the spec
+	defaultUDPRetry                    = 1               // how many times a request is sent before giving up on it
+	defaultUDPTimeout                  = 5 * time.Second // how long to wait for a response before resending or giving up
+	defaultMaxPeerFails                = 3               // after this many failures, a peer is considered bad and will be removed from the routing table
+	defaultRefreshInterval             = 1 * time.Hour   // the time after which an otherwise unaccessed bucket must be refreshed
+	defaultTokenSecretRotationInterval = 5 * time.Minute // how often the token-generating secret is rotated
+	defaultHealthCheckInterval         = 1 * time.Minute // how often the routing table is checked to see if the node needs to rejoin
+	defaultMinContacts                 = 2               // if the routing table has fewer contacts than this, the node rejoins the network
+
 	nodeIDLength    = bits.NumBytes // bytes. this is the constant B in the spec
 	messageIDLength = 20            // bytes.
 
-	udpRetry            = 1
-	udpTimeout          = 5 * time.Second
 	udpMaxMessageLength = 4096 // bytes. I think our longest message is ~676 bytes, so I rounded up to 1024
 	//                            scratch that. a findValue could return more than K results if a lot of nodes are storing that value, so we need more buffer
+	//                            findValue results are now paged, so they stay under maxPeersPerResponse
 
-	maxPeerFails = 3 // after this many failures, a peer is considered bad and will be removed from the routing table
-	//tExpire     = 60 * time.Minute // the time after which a key/value pair expires; this is a time-to-live (TTL) from the original publication date
-	tRefresh = 1 * time.Hour // the time after which an otherwise unaccessed bucket must be refreshed
+	// the most peers sent in one findValue response. 512 bytes are left for the rest of the response, and each peer
+	// takes 3 more bytes for its length prefix
+	maxPeersPerResponse = (udpMaxMessageLength - 512) / (compactNodeInfoLengthIPv6 + 3)
+
+	tExpire = 60 * time.Minute // the time after which a key/value pair expires; this is a time-to-live (TTL) from the original publication date
 	//tReplicate   = 1 * time.Hour    // the interval between Kademlia replication events, when a node is required to publish its entire database
 	//tNodeRefresh = 15 * time.Minute // the time after which a good node becomes questionable if it has not messaged us
 
-	compactNodeInfoLength = nodeIDLength + 6 // nodeID + 4 for IP + 2 for port
+	compactNodeInfoLength     = nodeIDLength + 6  // nodeID + 4 for IP + 2 for port
+	compactNodeInfoLengthIPv6 = nodeIDLength + 18 // nodeID + 16 for IP + 2 for port
+
+	// the protocol version sent with requests. version 1 is the python daemon's. version 2 adds IPv6 contacts
+	protocolVersion       = 2
+	protocolVersionPaging = 1 // nodes at this version or above ask for findValue results one page at a time
+	protocolVersionIPv6   = 2 // nodes at this version or above can decode IPv6 contacts
 
-	tokenSecretRotationInterval = 5 * time.Minute // how often the token-generating secret is rotated
+	storeSweepInterval   = 5 * time.Minute  // how often expired peers are removed from the contact store
+	limiterSweepInterval = 1 * time.Minute  // how often IPs that have stopped sending requests are forgotten by the rate limiter
+	stateSaveInterval    = 10 * time.Minute // how often the state file is saved, in addition to saving it on shutdown
 )
 
 // Config represents the configure of dht.
 type Config struct {
-	// this node's address. format is `ip:port`
+	// this node's address. format is `ip:port`. use `[::]:port` to listen on both IPv4 and IPv6. IPv4 and IPv6
+	// contacts share the routing table, but lookups skip contacts the node cannot reach
 	Address string
 	// the seed nodes through which we can join in dht network
 	SeedNodes []string
@@ -52,14 +75,73 @@ type Config struct {
 	PeerProtocolPort int
 	// if nonzero, an RPC server will listen to requests on this port and respond to them
 	RPCPort int
-	// the time after which the original publisher must reannounce a key/value pair
+	// if set, RPC requests must have an "Authorization: Bearer <token>" header with this token
+	RPCAuthToken string
+	// the time after which the original publisher must reannounce a key/value pair. if zero, DefaultReannounceTime is used
 	ReannounceTime time.Duration
-	// send at most this many announces per second
+	// send at most this many announces per second. if zero, DefaultAnnounceRate is used
 	AnnounceRate int
+	// the time after which a stored peer is forgotten unless it reannounces the hash. if zero, tExpire is used
+	PeerExpiration time.Duration
+	// if set, the peers that other nodes announce are kept in a bolt database at this path, so they survive restarts.
+	// otherwise they are kept in memory
+	PeerStoreFile string
+	// if set, the peers that other nodes announce are kept in this store. it takes precedence over PeerStoreFile, and is
+	// expected to enforce its own expiration and limits. the node closes it on shutdown
+	PeerStore PeerStore
+	// if set, the routing table and announced hashes are saved to this file and reloaded on the next start
+	StateFile string
+	// the number of contacts queried in parallel during a lookup. this is the constant alpha in the spec
+	Alpha int
+	// the number of contacts in a routing table bucket, and the number of contacts returned by a lookup. this is the
+	// constant k in the spec
+	BucketSize int
+	// how many times a request is sent before giving up on it
+	UDPRetry int
+	// how long to wait for a response to a request before resending it or giving up
+	UDPTimeout time.Duration
+	// after this many failures, a peer is considered bad and can be replaced in the routing table
+	MaxPeerFails int
+	// the time after which an otherwise unaccessed bucket must be refreshed
+	RefreshInterval time.Duration
+	// how often the secret used to make store tokens is rotated
+	TokenSecretRotationInterval time.Duration
+	// how often the routing table is checked to see if the node is isolated and needs to rejoin the network
+	HealthCheckInterval time.Duration
+	// if the routing table has fewer contacts than this, the node pings the seed nodes again to rejoin the network
+	MinContacts int
+	// if set, every blob in the source is announced, and blobs are added to or removed from the announce queue as
+	// they are added to or deleted from the source
+	BlobSource BlobSource
+	// if set, receives events from the node as they happen, such as packets sent and received
+	Metrics Metrics
+	// the number of requests per second accepted from each IP. requests over the limit are dropped. zero means no limit
+	RequestRate float64
+	// the number of requests an IP can send at once before RequestRate applies. defaults to one second of requests
+	RequestBurst int
+	// the most peers stored for one hash. stores of new peers for a full hash are rejected. zero means no limit
+	MaxPeersPerHash int
+	// the most hash/peer pairs stored from one IP. zero means no limit
+	MaxStoresPerIP int
+	// IPs and CIDR ranges, such as "1.2.3.4" or "1.2.3.0/24", whose packets are dropped
+	Blocklist []string
 	// channel that will receive notifications about announcements
+	//
+	// Deprecated: use DHT.SubscribeAnnounces instead. The notifications are now fed from a subscription, so they are
+	// dropped if the channel is not read quickly enough. AnnounceNotificationCh will be removed in the next release.
 	AnnounceNotificationCh chan announceNotification
 }
 
+// BlobSource lists the blobs that the DHT should announce, and tells it when that list changes. A
+// store.NotifyingStore satisfies this interface.
+type BlobSource interface {
+	// List returns the hex-encoded hashes of all the blobs
+	List() ([]string, error)
+	// Listen registers a function to be called when a blob is added or removed. Calling the returned function
+	// unregisters it.
+	Listen(fn func(hash string, added bool)) func()
+}
+
 // NewStandardConfig returns a Config pointer with default values.
 func NewStandardConfig() *Config {
 	return &Config{
@@ -73,5 +155,76 @@ func NewStandardConfig() *Config {
 		PeerProtocolPort: DefaultPeerPort,
 		ReannounceTime:   DefaultReannounceTime,
 		AnnounceRate:     DefaultAnnounceRate,
+		PeerExpiration:   tExpire,
+
+		Alpha:                       defaultAlpha,
+		BucketSize:                  defaultBucketSize,
+		UDPRetry:                    defaultUDPRetry,
+		UDPTimeout:                  defaultUDPTimeout,
+		MaxPeerFails:                defaultMaxPeerFails,
+		RefreshInterval:             defaultRefreshInterval,
+		TokenSecretRotationInterval: defaultTokenSecretRotationInterval,
+		HealthCheckInterval:         defaultHealthCheckInterval,
+		MinContacts:                 defaultMinContacts,
+
+		RequestRate:     DefaultRequestRate,
+		RequestBurst:    DefaultRequestBurst,
+		MaxPeersPerHash: DefaultMaxPeersPerHash,
+		MaxStoresPerIP:  DefaultMaxStoresPerIP,
+	}
+}
+
+// peerStoreOptions returns the expiration and limits for the peer store
+func (c *Config) peerStoreOptions() PeerStoreOptions {
+	return PeerStoreOptions{
+		Expiration:      c.PeerExpiration,
+		MaxPeersPerHash: c.MaxPeersPerHash,
+		MaxStoresPerIP:  c.MaxStoresPerIP,
+	}
+}
+
+// setDefaults sets the node parameters that are not set to their default values
+func (c *Config) setDefaults() {
+	if c.PeerExpiration <= 0 {
+		c.PeerExpiration = tExpire
+	}
+	if c.Alpha <= 0 {
+		c.Alpha = defaultAlpha
+	}
+	if c.BucketSize <= 0 {
+		c.BucketSize = defaultBucketSize
+	}
+	if c.UDPRetry <= 0 {
+		c.UDPRetry = defaultUDPRetry
+	}
+	if c.UDPTimeout <= 0 {
+		c.UDPTimeout = defaultUDPTimeout
+	}
+	if c.MaxPeerFails <= 0 {
+		c.MaxPeerFails = defaultMaxPeerFails
+	}
+	if c.RefreshInterval <= 0 {
+		c.RefreshInterval = defaultRefreshInterval
+	}
+	if c.TokenSecretRotationInterval <= 0 {
+		c.TokenSecretRotationInterval = defaultTokenSecretRotationInterval
+	}
+	if c.HealthCheckInterval <= 0 {
+		c.HealthCheckInterval = defaultHealthCheckInterval
+	}
+	if c.MinContacts <= 0 {
+		c.MinContacts = defaultMinContacts
+	}
+	if c.ReannounceTime <= 0 {
+		c.ReannounceTime = DefaultReannounceTime
+	}
+	if c.AnnounceRate <= 0 {
+		c.AnnounceRate = DefaultAnnounceRate
+	}
+	if c.RequestRate > 0 && c.RequestBurst <= 0 {
+		c.RequestBurst = defaultBurst(c.RequestRate)
+	}
+	if c.Metrics == nil {
+		c.Metrics = noMetrics{}
 	}
 }
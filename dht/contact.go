@@ -13,8 +13,6 @@ import (
 	"github.com/lyoshenka/bencode"
 )
 
-// TODO: if routing table is ever empty (aka the node is isolated), it should re-bootstrap
-
 // Contact contains information for contacting another node on the network
 type Contact struct {
 	ID       bits.Bitmap
@@ -56,10 +54,16 @@ func (c Contact) MarshalJSON() ([]byte, error) {
 	})
 }
 
-// MarshalCompact returns a compact byteslice representation of the contact
+// MarshalCompact returns a compact byteslice representation of the contact. IPv4 contacts take compactNodeInfoLength
+// bytes and IPv6 contacts take compactNodeInfoLengthIPv6 bytes. Only send IPv6 contacts to nodes whose protocol version
+// supports them.
 // NOTE: The compact representation always uses the tcp PeerPort, not the udp Port. This is dumb, but that's how the python daemon does it
 func (c Contact) MarshalCompact() ([]byte, error) {
-	if c.IP.To4() == nil {
+	ip := c.IP.To4()
+	if ip == nil {
+		ip = c.IP.To16()
+	}
+	if ip == nil {
 		return nil, errors.Err("ip not set")
 	}
 	if c.PeerPort < 0 || c.PeerPort > 65535 {
@@ -67,30 +71,43 @@ func (c Contact) MarshalCompact() ([]byte, error) {
 	}
 
 	var buf bytes.Buffer
-	buf.Write(c.IP.To4())
+	buf.Write(ip)
 	buf.WriteByte(byte(c.PeerPort >> 8))
 	buf.WriteByte(byte(c.PeerPort))
 	buf.Write(c.ID[:])
 
-	if buf.Len() != compactNodeInfoLength {
+	if buf.Len() != compactNodeInfoLength && buf.Len() != compactNodeInfoLengthIPv6 {
 		return nil, errors.Err("i dont know how this happened")
 	}
 
 	return buf.Bytes(), nil
 }
 
-// UnmarshalCompact unmarshals the compact byteslice representation of a contact.
+// UnmarshalCompact unmarshals the compact byteslice representation of a contact. The IP version is determined by the
+// length.
 // NOTE: The compact representation always uses the tcp PeerPort, not the udp Port. This is dumb, but that's how the python daemon does it
 func (c *Contact) UnmarshalCompact(b []byte) error {
-	if len(b) != compactNodeInfoLength {
+	var ipLength int
+	switch len(b) {
+	case compactNodeInfoLength:
+		ipLength = net.IPv4len
+	case compactNodeInfoLengthIPv6:
+		ipLength = net.IPv6len
+	default:
 		return errors.Err("invalid compact length")
 	}
-	c.IP = net.IPv4(b[0], b[1], b[2], b[3]).To4()
-	c.PeerPort = int(uint16(b[5]) | uint16(b[4])<<8)
-	c.ID = bits.FromBytesP(b[6:])
+
+	c.IP = append(net.IP(nil), b[:ipLength]...)
+	c.PeerPort = int(uint16(b[ipLength+1]) | uint16(b[ipLength])<<8)
+	c.ID = bits.FromBytesP(b[ipLength+2:])
 	return nil
 }
 
+// IsIPv6 returns true if the contact has an IPv6 address
+func (c Contact) IsIPv6() bool {
+	return c.IP.To4() == nil && c.IP.To16() != nil
+}
+
 // MarshalBencode returns the serialized byte slice representation of a contact.
 func (c Contact) MarshalBencode() ([]byte, error) {
 	return bencode.EncodeBytes([]interface{}{c.ID, c.IP.String(), c.Port})
@@ -118,7 +135,7 @@ func (c *Contact) UnmarshalBencode(b []byte) error {
 	if err != nil {
 		return err
 	}
-	c.IP = net.ParseIP(ipStr).To4()
+	c.IP = parseIP(ipStr)
 	if c.IP == nil {
 		return errors.Err("invalid IP")
 	}
@@ -126,6 +143,30 @@ func (c *Contact) UnmarshalBencode(b []byte) error {
 	return bencode.DecodeBytes(raw[2], &c.Port)
 }
 
+// parseIP parses an IPv4 or IPv6 address. IPv4 addresses are returned in their 4-byte form
+func parseIP(s string) net.IP {
+	ip := net.ParseIP(s)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+// contactsForVersion returns the contacts that a node with the given protocol version can decode. Nodes older than
+// protocolVersionIPv6 fail to decode a message with an IPv6 contact, so those contacts are left out.
+func contactsForVersion(contacts []Contact, version int) []Contact {
+	if version >= protocolVersionIPv6 {
+		return contacts
+	}
+	filtered := contacts[:0:0]
+	for _, c := range contacts {
+		if !c.IsIPv6() {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
 func sortByDistance(contacts []Contact, target bits.Bitmap) {
 	sort.Slice(contacts, func(i, j int) bool {
 		return contacts[i].ID.Xor(target).Cmp(contacts[j].ID.Xor(target)) < 0
@@ -29,3 +29,65 @@ func TestCompactEncoding(t *testing.T) {
 		t.Errorf("compact bytes not encoded correctly")
 	}
 }
+
+func TestCompactEncoding_IPv6(t *testing.T) {
+	c := Contact{
+		ID:       bits.Rand(),
+		IP:       net.ParseIP("2001:db8::68"),
+		PeerPort: 3333,
+	}
+
+	compact, err := c.MarshalCompact()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compact) != compactNodeInfoLengthIPv6 {
+		t.Fatalf("got length of %d; expected %d", len(compact), compactNodeInfoLengthIPv6)
+	}
+
+	var decoded Contact
+	err = decoded.UnmarshalCompact(compact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.IP.Equal(c.IP) || decoded.PeerPort != c.PeerPort || !decoded.ID.Equals(c.ID) {
+		t.Errorf("decoded contact %s does not match %s", decoded.String(), c.String())
+	}
+	if !decoded.IsIPv6() {
+		t.Error("decoded contact should be IPv6")
+	}
+}
+
+func TestBencode_IPv6(t *testing.T) {
+	c := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::68"), Port: 4444}
+
+	encoded, err := c.MarshalBencode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded Contact
+	err = decoded.UnmarshalBencode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Equals(c, true) {
+		t.Errorf("decoded contact %s does not match %s", decoded.String(), c.String())
+	}
+}
+
+func TestContactsForVersion(t *testing.T) {
+	v4 := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4")}
+	v6 := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::68")}
+	contacts := []Contact{v4, v6}
+
+	if filtered := contactsForVersion(contacts, 1); len(filtered) != 1 || !filtered[0].Equals(v4, true) {
+		t.Errorf("old nodes should only get IPv4 contacts, got %v", filtered)
+	}
+	if filtered := contactsForVersion(contacts, protocolVersionIPv6); len(filtered) != 2 {
+		t.Errorf("new nodes should get all contacts, got %v", filtered)
+	}
+	if len(contacts) != 2 || !contacts[1].Equals(v6, true) {
+		t.Error("filtering should not change the original slice")
+	}
+}
@@ -0,0 +1,267 @@
+// Package crawler maps the DHT by asking every node it can reach for its contacts, and counts the peers that store
+// blobs.
+package crawler
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultAddress        = "0.0.0.0:0"
+	defaultTargetsPerNode = 8
+	defaultConcurrency    = 20
+)
+
+// Config configures a crawl
+type Config struct {
+	// nodes to start crawling from, as host:port
+	SeedNodes []string
+	// address the crawler listens on. the crawler is a regular node, so the nodes it contacts can reach it
+	Address string
+	// each node is asked for the contacts closest to this many targets, spread evenly over the address space. more
+	// targets find more of each node's routing table, at the cost of more requests
+	TargetsPerNode int
+	// how many nodes are queried at once
+	Concurrency int
+	// how long to wait for each response. zero means the node's default
+	Timeout time.Duration
+	// the most nodes to query. zero means no limit
+	MaxNodes int
+	// blob hashes to look up on the nodes that responded
+	Hashes []bits.Bitmap
+	// each hash is looked up on this many of the responding nodes closest to it. zero means every responding node
+	ProbeClosest int
+}
+
+func (c *Config) setDefaults() {
+	if c.Address == "" {
+		c.Address = defaultAddress
+	}
+	if c.TargetsPerNode <= 0 {
+		c.TargetsPerNode = defaultTargetsPerNode
+	}
+	if c.Concurrency <= 0 {
+		c.Concurrency = defaultConcurrency
+	}
+}
+
+// Crawler enumerates the nodes of the DHT
+type Crawler struct {
+	conf Config
+}
+
+// New returns a crawler. Options that are not set get their default values.
+func New(conf *Config) *Crawler {
+	c := *conf
+	c.setDefaults()
+	return &Crawler{conf: c}
+}
+
+// nodeResult is what one node said when it was queried
+type nodeResult struct {
+	info  NodeInfo
+	found []dht.Contact
+}
+
+// Crawl queries the seed nodes, then every node they know about, and so on until no new nodes are found. Then it looks
+// up the hashes. If ctx is canceled, the crawl stops early and the nodes found so far are returned along with the error.
+func (c *Crawler) Crawl(ctx context.Context) (*Result, error) {
+	result := &Result{Started: time.Now()}
+
+	nodeConf := dht.NewStandardConfig()
+	nodeConf.Address = c.conf.Address
+	nodeConf.UDPTimeout = c.conf.Timeout
+	node := dht.NewNodeWithConfig(bits.Rand(), nodeConf)
+	listener, err := net.ListenPacket(dht.Network, c.conf.Address)
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	err = node.Connect(listener.(*net.UDPConn))
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	defer node.Shutdown()
+
+	var queue []dht.Contact
+	for _, s := range c.conf.SeedNodes {
+		addr, err := net.ResolveUDPAddr(dht.Network, s)
+		if err != nil {
+			return nil, errors.Prefix("resolving seed node "+s, err)
+		}
+		queue = append(queue, dht.Contact{IP: addr.IP, Port: addr.Port})
+	}
+	seen := make(map[string]bool)
+	for _, s := range queue {
+		seen[addrKey(s)] = true
+	}
+
+	jobs := make(chan dht.Contact)
+	results := make(chan nodeResult)
+	for i := 0; i < c.conf.Concurrency; i++ {
+		go func() {
+			for contact := range jobs {
+				results <- c.queryNode(ctx, node, contact)
+			}
+		}()
+	}
+
+	inFlight := 0
+	queried := 0
+	done := ctx.Done()
+	for (len(queue) > 0 && ctx.Err() == nil) || inFlight > 0 {
+		var send chan dht.Contact
+		var next dht.Contact
+		if len(queue) > 0 && ctx.Err() == nil && (c.conf.MaxNodes <= 0 || queried < c.conf.MaxNodes) {
+			send = jobs
+			next = queue[0]
+		} else if inFlight == 0 {
+			break // hit the limit
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			inFlight++
+			queried++
+		case r := <-results:
+			inFlight--
+			result.Nodes = append(result.Nodes, r.info)
+			for _, found := range r.found {
+				if !seen[addrKey(found)] {
+					seen[addrKey(found)] = true
+					queue = append(queue, found)
+				}
+			}
+		case <-done:
+			// stop sending jobs. the queries in flight are canceled too, so their results come in quickly
+			done = nil
+		}
+	}
+	close(jobs)
+
+	log.Infof("crawler: queried %d nodes, %d responded", len(result.Nodes), len(result.Reachable()))
+
+	if ctx.Err() == nil {
+		for _, hash := range c.conf.Hashes {
+			result.Blobs = append(result.Blobs, c.probe(ctx, node, hash, result.Reachable()))
+		}
+	}
+
+	result.Finished = time.Now()
+	if ctx.Err() != nil {
+		return result, errors.Err(ctx.Err())
+	}
+	return result, nil
+}
+
+// queryNode asks the node for the contacts closest to each target. The first target is looked up with findValue, since
+// findValue responses say which protocol version the node speaks.
+func (c *Crawler) queryNode(ctx context.Context, node *dht.Node, contact dht.Contact) nodeResult {
+	r := nodeResult{info: NodeInfo{ID: contact.ID, IP: contact.IP, Port: contact.Port}}
+	// seed nodes are queried before their IDs are known
+	opts := dht.SendOptions{SkipIDCheck: contact.ID == bits.Bitmap{}}
+
+	found := make(map[string]bool)
+	for i := 1; i <= c.conf.TargetsPerNode; i++ {
+		target := bits.MaxRange().IntervalP(i, c.conf.TargetsPerNode).Rand()
+		method := dht.FindNodeMethod
+		if i == 1 {
+			method = dht.FindValueMethod
+		}
+
+		res := node.SendContext(ctx, contact, dht.Request{Method: method, Arg: &target}, opts)
+		if res == nil {
+			continue
+		}
+
+		r.info.Reachable = true
+		r.info.ID = res.NodeID
+		if method == dht.FindValueMethod {
+			r.info.ProtocolVersion = res.ProtocolVersion
+		}
+		for _, f := range res.Contacts {
+			if !found[addrKey(f)] {
+				found[addrKey(f)] = true
+				r.found = append(r.found, f)
+			}
+		}
+	}
+
+	r.info.Contacts = len(r.found)
+	return r
+}
+
+// probe looks up the hash on the nodes closest to it, and collects the peers they have for it
+func (c *Crawler) probe(ctx context.Context, node *dht.Node, hash bits.Bitmap, nodes []NodeInfo) BlobInfo {
+	nodes = append([]NodeInfo(nil), nodes...)
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID.Xor(hash).Cmp(nodes[j].ID.Xor(hash)) < 0
+	})
+	if c.conf.ProbeClosest > 0 && len(nodes) > c.conf.ProbeClosest {
+		nodes = nodes[:c.conf.ProbeClosest]
+	}
+
+	jobs := make(chan NodeInfo)
+	results := make(chan []dht.Contact)
+	for i := 0; i < c.conf.Concurrency; i++ {
+		go func() {
+			for n := range jobs {
+				results <- c.findPeers(ctx, node, n.Contact(), hash)
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for _, n := range nodes {
+			jobs <- n
+		}
+	}()
+
+	blob := BlobInfo{Hash: hash}
+	seen := make(map[bits.Bitmap]bool)
+	for range nodes {
+		peers := <-results
+		if len(peers) > 0 {
+			blob.StoredOn++
+		}
+		for _, p := range peers {
+			if !seen[p.ID] {
+				seen[p.ID] = true
+				blob.Peers = append(blob.Peers, p)
+			}
+		}
+	}
+	return blob
+}
+
+// findPeers returns the peers the contact has for the hash, asking for every page of them
+func (c *Crawler) findPeers(ctx context.Context, node *dht.Node, contact dht.Contact, hash bits.Bitmap) []dht.Contact {
+	var peers []dht.Contact
+	pages := 1
+	for page := 0; page < pages; page++ {
+		res := node.SendContext(ctx, contact, dht.Request{Method: dht.FindValueMethod, Arg: &hash, Page: page})
+		if res == nil || res.FindValueKey != hash.RawString() {
+			break
+		}
+		peers = append(peers, res.Contacts...)
+		if page == 0 && res.Page > 1 {
+			pages = int(res.Page)
+		}
+	}
+	return peers
+}
+
+func addrKey(c dht.Contact) string {
+	return net.JoinHostPort(c.IP.String(), strconv.Itoa(c.Port))
+}
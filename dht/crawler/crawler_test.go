@@ -0,0 +1,181 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+func TestCrawler_Crawl(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow crawler test")
+	}
+
+	// dht.TestingCreateNetwork uses the same ports as the dht tests, which may be running at the same time
+	bs := dht.NewBootstrapNode(bits.Rand(), 0, time.Hour)
+	listener, err := net.ListenPacket(dht.Network, "127.0.0.1:21340")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.Connect(listener.(*net.UDPConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Shutdown()
+
+	var dhts []*dht.DHT
+	for i := 1; i <= 3; i++ {
+		conf := dht.NewStandardConfig()
+		conf.Address = "127.0.0.1:" + strconv.Itoa(21340+i)
+		conf.SeedNodes = []string{"127.0.0.1:21340"}
+		conf.RequestRate = 0
+		d := dht.New(conf)
+		go func() {
+			err := d.Start()
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+		d.WaitUntilJoined()
+		defer d.Shutdown()
+		dhts = append(dhts, d)
+	}
+
+	hash := bits.Rand()
+	_, err = dhts[0].Announce(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	c := New(&Config{
+		SeedNodes:      []string{"127.0.0.1:21340"},
+		Address:        "127.0.0.1:21330",
+		TargetsPerNode: 2,
+		Timeout:        time.Second,
+		Hashes:         []bits.Bitmap{hash},
+	})
+	result, err := c.Crawl(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bootstrap node and the three nodes
+	reachable := result.Reachable()
+	if len(reachable) != 4 {
+		t.Fatalf("expected 4 reachable nodes, got %d of %d", len(reachable), len(result.Nodes))
+	}
+	ports := make(map[int]bool)
+	for _, n := range reachable {
+		ports[n.Port] = true
+		if n.ID == (bits.Bitmap{}) {
+			t.Errorf("node at port %d has no ID", n.Port)
+		}
+	}
+	for port := 21340; port <= 21343; port++ {
+		if !ports[port] {
+			t.Errorf("node at port %d was not found", port)
+		}
+	}
+
+	// the bootstrap node does not answer findValue, so only the three nodes said which version they speak
+	versions := result.ProtocolVersions()
+	if versions[0] != 1 || len(versions) != 2 {
+		t.Errorf("expected one node without a protocol version and three with one, got %v", versions)
+	}
+
+	if len(result.Blobs) != 1 {
+		t.Fatalf("expected 1 probed blob, got %d", len(result.Blobs))
+	}
+	blob := result.Blobs[0]
+	if blob.StoredOn < 1 {
+		t.Error("expected the hash to be stored on at least one node")
+	}
+	if len(blob.Peers) != 1 {
+		t.Errorf("expected 1 peer for the hash, got %d", len(blob.Peers))
+	}
+}
+
+func TestResult_Write(t *testing.T) {
+	hash := bits.Rand()
+	peer := dht.Contact{ID: bits.Rand(), IP: []byte{1, 2, 3, 4}, PeerPort: 3333}
+	result := &Result{
+		Nodes: []NodeInfo{
+			{ID: bits.Rand(), IP: []byte{1, 2, 3, 4}, Port: 4444, Reachable: true, ProtocolVersion: 2, Contacts: 8},
+			{IP: []byte{5, 6, 7, 8}, Port: 4444},
+		},
+		Blobs: []BlobInfo{{Hash: hash, StoredOn: 2, Peers: []dht.Contact{peer}}},
+	}
+
+	var buf bytes.Buffer
+	err := result.WriteJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded struct {
+		Nodes []struct {
+			ID              string
+			Port            int
+			Reachable       bool
+			ProtocolVersion int
+		}
+		Blobs []struct {
+			Hash     string
+			StoredOn int
+			Peers    []struct {
+				ID       string
+				PeerPort int
+			}
+		}
+	}
+	err = json.Unmarshal(buf.Bytes(), &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Nodes) != 2 || decoded.Nodes[0].ID != result.Nodes[0].ID.Hex() || decoded.Nodes[0].ProtocolVersion != 2 {
+		t.Errorf("nodes were not written correctly: %s", buf.String())
+	}
+	if len(decoded.Blobs) != 1 || decoded.Blobs[0].Hash != hash.Hex() || decoded.Blobs[0].StoredOn != 2 ||
+		len(decoded.Blobs[0].Peers) != 1 || decoded.Blobs[0].Peers[0].ID != peer.ID.Hex() {
+		t.Errorf("blobs were not written correctly: %s", buf.String())
+	}
+
+	buf.Reset()
+	err = result.WriteNodesCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("expected a header and 2 rows, got %d rows", len(rows))
+	}
+	if rows[1][0] != result.Nodes[0].ID.Hex() || rows[1][1] != "1.2.3.4" || rows[1][3] != "true" || rows[2][3] != "false" {
+		t.Errorf("unexpected node rows %v", rows[1:])
+	}
+
+	buf.Reset()
+	err = result.WriteBlobsCSV(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err = csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[1][0] != hash.Hex() || rows[1][1] != "2" || rows[1][2] != "1" {
+		t.Errorf("unexpected blob rows %v", rows)
+	}
+}
@@ -0,0 +1,153 @@
+package crawler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// NodeInfo describes a node the crawler tried to query
+type NodeInfo struct {
+	ID   bits.Bitmap
+	IP   net.IP
+	Port int
+	// whether the node answered at least one request. the ID of a seed node that never answered is not known
+	Reachable bool
+	// the protocol version the node sent. zero if it did not send one, which old nodes don't
+	ProtocolVersion int
+	// the number of distinct contacts the node sent
+	Contacts int
+}
+
+// Contact returns the node as a contact
+func (n NodeInfo) Contact() dht.Contact {
+	return dht.Contact{ID: n.ID, IP: n.IP, Port: n.Port}
+}
+
+// MarshalJSON implements json.Marshaler
+func (n NodeInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		ID              string
+		IP              string
+		Port            int
+		Reachable       bool
+		ProtocolVersion int
+		Contacts        int
+	}{
+		ID:              n.ID.Hex(),
+		IP:              n.IP.String(),
+		Port:            n.Port,
+		Reachable:       n.Reachable,
+		ProtocolVersion: n.ProtocolVersion,
+		Contacts:        n.Contacts,
+	})
+}
+
+// BlobInfo describes who has a blob
+type BlobInfo struct {
+	Hash bits.Bitmap
+	// the number of probed nodes that had peers for the hash
+	StoredOn int
+	// the distinct peers that announced the hash
+	Peers []dht.Contact
+}
+
+// MarshalJSON implements json.Marshaler
+func (b BlobInfo) MarshalJSON() ([]byte, error) {
+	peers := b.Peers
+	if peers == nil {
+		peers = []dht.Contact{}
+	}
+	return json.Marshal(&struct {
+		Hash     string
+		StoredOn int
+		Peers    []dht.Contact
+	}{
+		Hash:     b.Hash.Hex(),
+		StoredOn: b.StoredOn,
+		Peers:    peers,
+	})
+}
+
+// Result is everything a crawl found
+type Result struct {
+	Started  time.Time
+	Finished time.Time
+	Nodes    []NodeInfo
+	Blobs    []BlobInfo
+}
+
+// Reachable returns the nodes that answered
+func (r *Result) Reachable() []NodeInfo {
+	var nodes []NodeInfo
+	for _, n := range r.Nodes {
+		if n.Reachable {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// ProtocolVersions returns the number of reachable nodes that speak each protocol version
+func (r *Result) ProtocolVersions() map[int]int {
+	versions := make(map[int]int)
+	for _, n := range r.Reachable() {
+		versions[n.ProtocolVersion]++
+	}
+	return versions
+}
+
+// WriteJSON writes the whole result as JSON
+func (r *Result) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errors.Err(enc.Encode(r))
+}
+
+// WriteNodesCSV writes one row per node
+func (r *Result) WriteNodesCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"id", "ip", "port", "reachable", "protocol_version", "contacts"})
+	if err != nil {
+		return errors.Err(err)
+	}
+	for _, n := range r.Nodes {
+		err = cw.Write([]string{
+			n.ID.Hex(),
+			n.IP.String(),
+			strconv.Itoa(n.Port),
+			strconv.FormatBool(n.Reachable),
+			strconv.Itoa(n.ProtocolVersion),
+			strconv.Itoa(n.Contacts),
+		})
+		if err != nil {
+			return errors.Err(err)
+		}
+	}
+	cw.Flush()
+	return errors.Err(cw.Error())
+}
+
+// WriteBlobsCSV writes one row per probed hash, with the number of nodes storing it and the number of peers that have it
+func (r *Result) WriteBlobsCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	err := cw.Write([]string{"hash", "stored_on", "peers"})
+	if err != nil {
+		return errors.Err(err)
+	}
+	for _, b := range r.Blobs {
+		err = cw.Write([]string{b.Hash.Hex(), strconv.Itoa(b.StoredOn), strconv.Itoa(len(b.Peers))})
+		if err != nil {
+			return errors.Err(err)
+		}
+	}
+	cw.Flush()
+	return errors.Err(cw.Error())
+}
@@ -1,9 +1,11 @@
 package dht
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
@@ -42,6 +44,12 @@ type DHT struct {
 	tokenCache *tokenCache
 	// hashes that need to be put into the announce queue or removed from the queue
 	announceAddRemove chan queueEdit
+	// hashes that are in the announce queue, and when to announce them. kept in sync by the announcer
+	scheduler *announceScheduler
+	// sends announce events to subscribers
+	announceEvents *announceEvents
+	// the join state, kept up to date by the health check
+	health *joinHealth
 }
 
 // New returns a DHT pointer. If config is nil, then config will be set to the default config.
@@ -49,12 +57,17 @@ func New(config *Config) *DHT {
 	if config == nil {
 		config = NewStandardConfig()
 	}
+	defaults := *config
+	defaults.setDefaults()
 
 	d := &DHT{
 		conf:              config,
 		grp:               stop.New(),
 		joined:            make(chan struct{}),
 		announceAddRemove: make(chan queueEdit),
+		scheduler:         newAnnounceScheduler(defaults.ReannounceTime, defaults.AnnounceRate),
+		announceEvents:    newAnnounceEvents(),
+		health:            newJoinHealth(),
 	}
 	return d
 }
@@ -65,15 +78,45 @@ func (dht *DHT) connect(conn UDPConn) error {
 		return err
 	}
 
-	dht.contact = contact
-	dht.node = NewNode(contact.ID)
-	dht.tokenCache = newTokenCache(dht.node, tokenSecretRotationInterval)
+	conf := *dht.conf
+	conf.setDefaults()
+	openedStore := false
+	if conf.PeerStore == nil && conf.PeerStoreFile != "" {
+		conf.PeerStore, err = OpenBoltPeerStore(conf.PeerStoreFile, conf.peerStoreOptions())
+		if err != nil {
+			return err
+		}
+		openedStore = true
+	}
 
-	return dht.node.Connect(conn)
+	dht.contact = contact
+	dht.node = NewNodeWithConfig(contact.ID, &conf)
+	dht.tokenCache = newTokenCache(dht.node, dht.node.conf.TokenSecretRotationInterval)
+
+	err = dht.node.Connect(conn)
+	if err != nil && openedStore {
+		// the node never started, so it won't close the store on shutdown
+		closeErr := conf.PeerStore.Close()
+		if closeErr != nil {
+			log.Error(errors.Prefix("closing peer store", closeErr))
+		}
+	}
+	return err
 }
 
 // Start starts the dht
 func (dht *DHT) Start() error {
+	var state *savedState
+	if dht.conf.StateFile != "" {
+		var err error
+		state, err = loadState(dht.conf.StateFile)
+		if err != nil {
+			log.Error(errors.Prefix("loading dht state, starting fresh", err))
+		} else if state != nil && dht.conf.NodeID == "" {
+			dht.conf.NodeID = state.nodeID // keep the same node id across restarts
+		}
+	}
+
 	listener, err := net.ListenPacket(Network, dht.conf.Address)
 	if err != nil {
 		return errors.Err(err)
@@ -85,16 +128,53 @@ func (dht *DHT) Start() error {
 		return err
 	}
 
-	dht.join()
+	var knownContacts []Contact
+	if state != nil {
+		knownContacts = state.contacts
+	}
+
+	dht.join(knownContacts)
 	log.Infof("[%s] DHT ready on %s (%d nodes found during join)",
 		dht.node.id.HexShort(), dht.contact.Addr().String(), dht.node.rt.Count())
 
+	if dht.conf.AnnounceNotificationCh != nil {
+		dht.forwardAnnounceNotifications(dht.conf.AnnounceNotificationCh)
+	}
+
+	// restored hashes go straight into the schedule, so they are saved again even if the DHT shuts down right away
+	if state != nil {
+		for _, hash := range state.hashes {
+			dht.scheduler.Add(hash)
+		}
+	}
+
 	dht.grp.Add(1)
 	go func() {
 		dht.runAnnouncer()
 		dht.grp.Done()
 	}()
 
+	dht.grp.Add(1)
+	go func() {
+		dht.runHealthCheck()
+		dht.grp.Done()
+	}()
+
+	if dht.conf.BlobSource != nil {
+		err = dht.followBlobSource(dht.conf.BlobSource)
+		if err != nil {
+			log.Error(errors.Prefix("listing blobs to announce", err))
+		}
+	}
+
+	if dht.conf.StateFile != "" {
+		dht.grp.Add(1)
+		go func() {
+			dht.runStateSaver()
+			dht.grp.Done()
+		}()
+	}
+
 	if dht.conf.RPCPort > 0 {
 		dht.grp.Add(1)
 		go func() {
@@ -106,26 +186,43 @@ func (dht *DHT) Start() error {
 	return nil
 }
 
-// join makes current node join the dht network.
-func (dht *DHT) join() {
+// join makes current node join the dht network. Known contacts (e.g. from a previous run) are tried first, and the
+// seed nodes are only used if none of them respond.
+func (dht *DHT) join(known []Contact) {
 	defer close(dht.joined) // if anyone's waiting for join to finish, they'll know its done
 
 	log.Infof("[%s] joining DHT network", dht.node.id.HexShort())
 
+	if dht.joinNetwork(known) {
+		dht.setJoinState(JoinStateJoined)
+	} else {
+		dht.setJoinState(JoinStateIsolated)
+	}
+}
+
+// joinNetwork pings the known contacts, or the seed nodes if none of the known contacts respond, and then looks up
+// this node's own ID to fill the routing table. It returns false if no nodes responded.
+func (dht *DHT) joinNetwork(known []Contact) bool {
+	atLeastOneNodeResponded := dht.pingKnown(known) > 0
+	if !atLeastOneNodeResponded && len(known) > 0 {
+		log.Infof("[%s] join: none of the %d saved contacts responded, falling back to seed nodes", dht.node.id.HexShort(), len(known))
+	}
+
 	// ping nodes, which gets their real node IDs and adds them to the routing table
-	atLeastOneNodeResponded := false
-	for _, addr := range dht.conf.SeedNodes {
-		err := dht.Ping(addr)
-		if err != nil {
-			log.Error(errors.Prefix(fmt.Sprintf("[%s] join", dht.node.id.HexShort()), err))
-		} else {
-			atLeastOneNodeResponded = true
+	if !atLeastOneNodeResponded {
+		for _, addr := range dht.conf.SeedNodes {
+			err := dht.Ping(addr)
+			if err != nil {
+				log.Error(errors.Prefix(fmt.Sprintf("[%s] join", dht.node.id.HexShort()), err))
+			} else {
+				atLeastOneNodeResponded = true
+			}
 		}
 	}
 
 	if !atLeastOneNodeResponded {
 		log.Errorf("[%s] join: no nodes responded to initial ping", dht.node.id.HexShort())
-		return
+		return false
 	}
 
 	// now call iterativeFind on yourself
@@ -134,8 +231,57 @@ func (dht *DHT) join() {
 		log.Errorf("[%s] join: %s", dht.node.id.HexShort(), err.Error())
 	}
 
-	// TODO: after joining, refresh all buckets further away than our closest neighbor
+	// refresh the buckets further away than our closest neighbor, so we know about the rest of the network too. this is
+	// done in the background so the node is usable right away
 	// http://xlattice.sourceforge.net/components/protocol/kademlia/specs.html#join
+	dht.grp.Add(1)
+	go func() {
+		defer dht.grp.Done()
+		dht.refreshDistantBuckets()
+	}()
+
+	return true
+}
+
+// refreshDistantBuckets looks up a random ID in every bucket that is further away than this node's closest neighbor
+func (dht *DHT) refreshDistantBuckets() {
+	closest := dht.node.rt.GetClosest(dht.node.id, 1)
+	if len(closest) == 0 {
+		return
+	}
+	refreshIDs(dht.node, dht.node.rt.GetIDsFartherThan(closest[0].ID.Xor(dht.node.id)), dht.grp)
+}
+
+// RefreshBuckets looks up a random ID in every bucket of the routing table, and returns the number of buckets. It
+// blocks until the lookups are done.
+func (dht *DHT) RefreshBuckets() int {
+	ids := dht.node.rt.GetIDsForRefresh(0)
+	refreshIDs(dht.node, ids, dht.grp)
+	return len(ids)
+}
+
+// pingKnown pings contacts whose IDs we already know. The ones that respond are added to the routing table. It returns
+// the number of contacts that responded.
+func (dht *DHT) pingKnown(contacts []Contact) int {
+	var responded int
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, c := range contacts {
+		if c.ID.Equals(dht.node.id) {
+			continue
+		}
+		wg.Add(1)
+		go func(c Contact) {
+			defer wg.Done()
+			if res := dht.node.Send(c, Request{Method: pingMethod}); res != nil {
+				mu.Lock()
+				responded++
+				mu.Unlock()
+			}
+		}(c)
+	}
+	wg.Wait()
+	return responded
 }
 
 // WaitUntilJoined blocks until the node joins the network.
@@ -150,6 +296,13 @@ func (dht *DHT) WaitUntilJoined() {
 func (dht *DHT) Shutdown() {
 	log.Debugf("[%s] DHT shutting down", dht.node.id.HexShort())
 	dht.grp.StopAndWait()
+	dht.announceEvents.close()
+	if dht.conf.StateFile != "" {
+		err := dht.saveState()
+		if err != nil {
+			log.Error(errors.Prefix("saving dht state", err))
+		}
+	}
 	dht.node.Shutdown()
 	log.Debugf("[%s] DHT stopped", dht.node.id.HexShort())
 }
@@ -157,14 +310,22 @@ func (dht *DHT) Shutdown() {
 // Ping pings a given address, creates a temporary contact for sending a message, and returns an error if communication
 // fails.
 func (dht *DHT) Ping(addr string) error {
-	raddr, err := net.ResolveUDPAddr(Network, addr)
+	return dht.PingContext(context.Background(), addr)
+}
+
+// PingContext is like Ping, but gives up when ctx is canceled
+func (dht *DHT) PingContext(ctx context.Context, addr string) error {
+	raddr, err := net.ResolveUDPAddr(dht.node.network(), addr)
 	if err != nil {
 		return err
 	}
 
 	tmpNode := Contact{ID: bits.Rand(), IP: raddr.IP, Port: raddr.Port}
-	res := dht.node.Send(tmpNode, Request{Method: pingMethod}, SendOptions{skipIDCheck: true})
+	res := dht.node.SendContext(ctx, tmpNode, Request{Method: pingMethod}, SendOptions{SkipIDCheck: true})
 	if res == nil {
+		if ctx.Err() != nil {
+			return errors.Err(ctx.Err())
+		}
 		return errors.Err("no response from node %s", addr)
 	}
 
@@ -173,7 +334,12 @@ func (dht *DHT) Ping(addr string) error {
 
 // Get returns the list of nodes that have the blob for the given hash
 func (dht *DHT) Get(hash bits.Bitmap) ([]Contact, error) {
-	contacts, found, err := FindContacts(dht.node, hash, true, dht.grp.Child())
+	return dht.GetContext(context.Background(), hash)
+}
+
+// GetContext is like Get, but the lookup is abandoned and an error is returned when ctx is canceled
+func (dht *DHT) GetContext(ctx context.Context, hash bits.Bitmap) ([]Contact, error) {
+	contacts, found, err := FindContactsContext(ctx, dht.node, hash, true, dht.grp.Child())
 	if err != nil {
 		return nil, err
 	}
@@ -184,6 +350,36 @@ func (dht *DHT) Get(hash bits.Bitmap) ([]Contact, error) {
 	return nil, nil
 }
 
+// GetStream searches for peers that have the blob for the given hash, and sends each unique peer on the returned
+// channel as soon as it's found. The search stops after count peers are found (or when there's nobody left to ask, if
+// count is 0), and then the channel is closed. The caller must read until the channel is closed or cancel ctx. A caller
+// that stops reading does not stall the search, but the goroutine sending on the channel is only released when ctx is
+// canceled or the DHT shuts down.
+func (dht *DHT) GetStream(ctx context.Context, hash bits.Bitmap, count int) <-chan Contact {
+	ch := make(chan Contact)
+	ctx, cancel := context.WithCancel(ctx)
+
+	dht.grp.Add(1)
+	go func() {
+		defer dht.grp.Done()
+		defer close(ch)
+		defer cancel()
+
+		_, err := FindPeersStream(ctx, dht.node, hash, count, dht.grp.Child(), func(c Contact) {
+			select {
+			case ch <- c:
+			case <-ctx.Done():
+			case <-dht.grp.Ch():
+			}
+		})
+		if err != nil && ctx.Err() == nil {
+			log.Error(errors.Prefix("get stream", err))
+		}
+	}()
+
+	return ch
+}
+
 // PrintState prints the current state of the DHT including address, nr outstanding transactions, stored hashes as well
 // as current bucket information.
 func (dht *DHT) PrintState() {
@@ -217,7 +413,7 @@ func getContact(nodeID, addr string) (Contact, error) {
 		return c, errors.Err("address does not contain a port")
 	}
 
-	c.IP = net.ParseIP(ip)
+	c.IP = parseIP(ip)
 	if c.IP == nil {
 		return c, errors.Err("invalid ip")
 	}
@@ -1,10 +1,10 @@
 package dht
 
 import (
-	"container/ring"
 	"context"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
@@ -29,25 +29,195 @@ type announceNotification struct {
 	err    error
 }
 
+// announceNotificationBuffer is how many announce events are buffered for Config.AnnounceNotificationCh
+const announceNotificationBuffer = 100
+
+// StoreStatus is the outcome of asking a contact to store a hash
+type StoreStatus string
+
+const (
+	StoreAccepted     StoreStatus = "accepted"
+	StoreInvalidToken StoreStatus = "invalid-token"
+	StoreTimeout      StoreStatus = "timeout"
+	StoreFailed       StoreStatus = "failed"
+)
+
+// StoreResult is the outcome of asking one contact to store a hash
+type StoreResult struct {
+	Contact Contact
+	Status  StoreStatus
+	// why the contact did not store the hash. nil if it was accepted
+	Err error
+	// true if the contact rejected the cached token, so the store was retried with a new token
+	TokenRefreshed bool
+}
+
+// AnnounceResult has the outcome of every store made by an announce
+type AnnounceResult struct {
+	Hash   bits.Bitmap
+	Stores []StoreResult
+}
+
+// StoredTo returns the number of contacts, including this node, that stored the hash
+func (r AnnounceResult) StoredTo() int {
+	n := 0
+	for _, s := range r.Stores {
+		if s.Status == StoreAccepted {
+			n++
+		}
+	}
+	return n
+}
+
 // Add adds the hash to the list of hashes this node is announcing
 func (dht *DHT) Add(hash bits.Bitmap) {
-	dht.announceAddRemove <- queueEdit{hash: hash, add: true}
+	select {
+	case dht.announceAddRemove <- queueEdit{hash: hash, add: true}:
+	case <-dht.grp.Ch():
+	}
 }
 
 // Remove removes the hash from the list of hashes this node is announcing
 func (dht *DHT) Remove(hash bits.Bitmap) {
-	dht.announceAddRemove <- queueEdit{hash: hash, add: false}
+	select {
+	case dht.announceAddRemove <- queueEdit{hash: hash, add: false}:
+	case <-dht.grp.Ch():
+	}
 }
 
-func (dht *DHT) runAnnouncer() {
-	type hashAndTime struct {
-		hash         bits.Bitmap
-		lastAnnounce time.Time
+// followBlobSource adds every blob in the source to the announce queue, and keeps the queue in sync with changes to
+// the source. Changes are listened for before listing, so none are missed. The listener only queues the change, so
+// writers to the source never wait for the announcer. The listener is unregistered when the DHT shuts down.
+func (dht *DHT) followBlobSource(src BlobSource) error {
+	var mu sync.Mutex
+	var pending []queueEdit
+	// blobs deleted while the initial list is being added. they must not be added after their delete is processed
+	deleted := make(map[bits.Bitmap]bool)
+	wake := make(chan struct{}, 1)
+
+	unlisten := src.Listen(func(hash string, added bool) {
+		h, err := bits.FromHex(hash)
+		if err != nil {
+			log.Error(errors.Prefix("blob source", err))
+			return
+		}
+
+		mu.Lock()
+		if added {
+			delete(deleted, h)
+		} else if deleted != nil {
+			deleted[h] = true
+		}
+		pending = append(pending, queueEdit{hash: h, add: added})
+		mu.Unlock()
+
+		select {
+		case wake <- struct{}{}:
+		default:
+		}
+	})
+
+	hashes, err := src.List()
+	if err != nil {
+		unlisten()
+		return err
 	}
 
-	var queue *ring.Ring
-	hashes := make(map[bits.Bitmap]*ring.Ring)
+	dht.grp.Add(1)
+	go func() {
+		defer dht.grp.Done()
+		defer unlisten()
 
+		for _, hash := range hashes {
+			h, err := bits.FromHex(hash)
+			if err != nil {
+				log.Error(errors.Prefix("blob source", err))
+				continue
+			}
+			mu.Lock()
+			skip := deleted[h]
+			mu.Unlock()
+			if !skip {
+				dht.Add(h)
+			}
+		}
+		mu.Lock()
+		deleted = nil
+		mu.Unlock()
+
+		for {
+			mu.Lock()
+			edits := pending
+			pending = nil
+			mu.Unlock()
+
+			for _, e := range edits {
+				if e.add {
+					dht.Add(e.hash)
+				} else {
+					dht.Remove(e.hash)
+				}
+			}
+
+			select {
+			case <-wake:
+			case <-dht.grp.Ch():
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// announcedHashes returns the hashes that are currently in the announce queue
+func (dht *DHT) announcedHashes() []bits.Bitmap {
+	return dht.scheduler.Hashes()
+}
+
+// AnnounceTimes returns the hashes this node is announcing, and when each one was last announced. The time is zero for
+// hashes that have not been announced yet.
+func (dht *DHT) AnnounceTimes() map[bits.Bitmap]time.Time {
+	return dht.scheduler.LastAnnounced()
+}
+
+// AnnounceStatus returns the state of the announce queue
+func (dht *DHT) AnnounceStatus() AnnounceStatus {
+	return dht.scheduler.Status(time.Now())
+}
+
+// forwardAnnounceNotifications sends announce events to the deprecated Config.AnnounceNotificationCh until the DHT
+// shuts down
+func (dht *DHT) forwardAnnounceNotifications(ch chan announceNotification) {
+	sub := dht.SubscribeAnnounces(announceNotificationBuffer, DropNewest)
+	dht.grp.Add(1)
+	go func() {
+		defer dht.grp.Done()
+		defer sub.Close()
+		for {
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				n := announceNotification{hash: e.Hash, action: announceStarted}
+				if e.Action == AnnounceFinished {
+					n.action = announceFinishd
+					n.err = e.Err
+				}
+				select {
+				case ch <- n:
+				case <-dht.grp.Ch():
+					return
+				}
+			case <-dht.grp.Ch():
+				return
+			}
+		}
+	}()
+}
+
+func (dht *DHT) runAnnouncer() {
 	var announceNextHash <-chan time.Time
 	timer := time.NewTimer(math.MaxInt64)
 	timer.Stop()
@@ -56,10 +226,13 @@ func (dht *DHT) runAnnouncer() {
 	dht.grp.Add(1)
 	go func() {
 		defer dht.grp.Done()
-		limiter := rate.NewLimiter(rate.Limit(dht.conf.AnnounceRate), dht.conf.AnnounceRate)
+		limiter := rate.NewLimiter(rate.Limit(dht.node.conf.AnnounceRate), dht.node.conf.AnnounceRate)
 		for {
-			err := limiter.Wait(context.Background()) // TODO: should use grp.ctx somehow? so when grp is closed, wait returns
+			err := limiter.Wait(dht.grp.Ctx())
 			if err != nil {
+				if dht.grp.Ctx().Err() != nil {
+					return
+				}
 				log.Error(errors.Prefix("rate limiter", err))
 				continue
 			}
@@ -73,7 +246,24 @@ func (dht *DHT) runAnnouncer() {
 
 	maintenance := time.NewTicker(1 * time.Minute)
 
-	// TODO: work to space hash announces out so they aren't bunched up around the reannounce time. track time since last announce. if its been more than the ideal time (reannounce time / numhashes), start announcing hashes early
+	metrics := dht.node.conf.Metrics
+	var lag time.Duration // how far behind the reannounce time the last announced hash was
+
+	// schedule waits for the rate limiter if the next hash is ready to be announced, or until it will be ready
+	schedule := func() {
+		_, wait, ok := dht.scheduler.Next(time.Now())
+		if !ok {
+			timer.Stop()
+			announceNextHash = nil // no hashes to announce, wait indefinitely
+		} else if wait <= 0 {
+			timer.Stop()
+			announceNextHash = limitCh // announce next hash ASAP
+		} else {
+			timer.Reset(wait)
+			announceNextHash = timer.C // wait until next hash should be announced
+		}
+	}
+	schedule() // hashes may have been scheduled before the announcer started
 
 	for {
 		select {
@@ -81,126 +271,137 @@ func (dht *DHT) runAnnouncer() {
 			return
 
 		case <-maintenance.C:
-			maxAnnounce := dht.conf.AnnounceRate * int(dht.conf.ReannounceTime.Seconds())
-			if len(hashes) > maxAnnounce {
-				// TODO: send this to slack
-				log.Warnf("DHT has %d hashes, but can only announce %d hashes in the %s reannounce window. Raise the announce rate or spawn more nodes.",
-					len(hashes), maxAnnounce, dht.conf.ReannounceTime.String())
+			status := dht.scheduler.Status(time.Now())
+			if status.Hashes > status.Capacity {
+				log.Warnf("DHT has %d hashes, but can only announce %d hashes in the %s reannounce window (%d are lagging). Raise the announce rate or spawn more nodes.",
+					status.Hashes, status.Capacity, dht.node.conf.ReannounceTime.String(), status.Lagging)
 			}
 
 		case change := <-dht.announceAddRemove:
 			if change.add {
-				if _, exists := hashes[change.hash]; exists {
+				if !dht.scheduler.Add(change.hash) {
 					continue
 				}
-
-				r := ring.New(1)
-				r.Value = hashAndTime{hash: change.hash}
-				if queue != nil {
-					queue.Prev().Link(r)
-				}
-				queue = r
-				hashes[change.hash] = r
-				announceNextHash = limitCh // announce next hash ASAP
 			} else {
-				r, exists := hashes[change.hash]
-				if !exists {
+				if !dht.scheduler.Remove(change.hash) {
 					continue
 				}
-
-				delete(hashes, change.hash)
-
-				if len(hashes) == 0 {
-					queue = ring.New(0)
-					announceNextHash = nil // no hashes to announce, wait indefinitely
-				} else {
-					if r == queue {
-						queue = queue.Next() // don't lose our pointer
-					}
-					r.Prev().Link(r.Next())
-				}
+				dht.announceEvents.forget(change.hash)
+				metrics.AnnounceQueue(dht.scheduler.Len(), lag)
 			}
+			schedule()
 
 		case <-announceNextHash:
-			dht.grp.Add(1)
-			ht := queue.Value.(hashAndTime)
-
-			if !ht.lastAnnounce.IsZero() {
-				nextAnnounce := ht.lastAnnounce.Add(dht.conf.ReannounceTime)
-				if nextAnnounce.After(time.Now()) {
-					timer.Reset(time.Until(nextAnnounce))
-					announceNextHash = timer.C // wait until next hash should be announced
-					continue
-				}
+			now := time.Now()
+			next, wait, ok := dht.scheduler.Next(now)
+			if !ok || wait > 0 {
+				schedule()
+				continue
 			}
 
-			if dht.conf.AnnounceNotificationCh != nil {
-				dht.conf.AnnounceNotificationCh <- announceNotification{
-					hash:   ht.hash,
-					action: announceStarted,
-				}
-			}
+			lag = dht.scheduler.lag(next, now)
+			metrics.AnnounceQueue(dht.scheduler.Len(), lag)
 
-			go func(hash bits.Bitmap) {
+			dht.announceEvents.publish(AnnounceEvent{Hash: next.hash, Action: AnnounceStarted, Time: now})
+
+			// the hash is only marked as announced once the announce succeeds. until then, it will be retried
+			dht.scheduler.Started(next.hash, now)
+			dht.grp.Add(1)
+			go func(hash bits.Bitmap, start time.Time) {
 				defer dht.grp.Done()
-				err := dht.announce(hash)
+				result, err := dht.Announce(hash)
 				if err != nil {
 					log.Error(errors.Prefix("announce", err))
+				} else {
+					dht.scheduler.Announced(hash, start)
 				}
 
-				if dht.conf.AnnounceNotificationCh != nil {
-					dht.conf.AnnounceNotificationCh <- announceNotification{
-						hash:   ht.hash,
-						action: announceFinishd,
-						err:    err,
-					}
-				}
-			}(ht.hash)
-
-			queue.Value = hashAndTime{hash: ht.hash, lastAnnounce: time.Now()}
-			queue = queue.Next()
-			announceNextHash = limitCh // announce next hash ASAP
+				end := time.Now()
+				dht.announceEvents.publish(AnnounceEvent{
+					Hash:     hash,
+					Action:   AnnounceFinished,
+					Time:     end,
+					Err:      err,
+					StoredTo: result.StoredTo(),
+					Stores:   result.Stores,
+					Duration: end.Sub(start),
+				})
+			}(next.hash, now)
+
+			schedule()
 		}
 	}
 }
 
-// Announce announces to the DHT that this node has the blob for the given hash
-func (dht *DHT) announce(hash bits.Bitmap) error {
+// Announce announces to the DHT that this node has the blob for the given hash, and returns the outcome of the store
+// sent to each of the closest contacts. It does not add the hash to the announce queue.
+func (dht *DHT) Announce(hash bits.Bitmap) (AnnounceResult, error) {
+	result := AnnounceResult{Hash: hash}
+
 	contacts, _, err := FindContacts(dht.node, hash, false, dht.grp.Child())
 	if err != nil {
-		return err
+		return result, err
 	}
 
 	// self-store if we found less than K contacts, or we're closer than the farthest contact
-	if len(contacts) < bucketSize {
+	k := dht.node.conf.BucketSize
+	if len(contacts) < k {
 		contacts = append(contacts, dht.contact)
-	} else if hash.Closer(dht.node.id, contacts[bucketSize-1].ID) {
-		contacts[bucketSize-1] = dht.contact
+	} else if hash.Closer(dht.node.id, contacts[k-1].ID) {
+		contacts[k-1] = dht.contact
 	}
 
+	result.Stores = make([]StoreResult, len(contacts))
 	wg := &sync.WaitGroup{}
-	for _, c := range contacts {
+	for i, c := range contacts {
 		wg.Add(1)
-		go func(c Contact) {
-			dht.store(hash, c)
-			wg.Done()
-		}(c)
+		go func(i int, c Contact) {
+			defer wg.Done()
+			result.Stores[i] = dht.store(hash, c)
+		}(i, c)
 	}
 
 	wg.Wait()
 
-	return nil
+	return result, nil
 }
 
-func (dht *DHT) store(hash bits.Bitmap, c Contact) {
+// store asks the contact to store this node as a peer for the hash. If the contact rejects the cached token, a new
+// token is fetched and the store is sent again.
+func (dht *DHT) store(hash bits.Bitmap, c Contact) StoreResult {
+	result := StoreResult{Contact: c}
+
 	if dht.contact.ID == c.ID {
 		// self-store
 		c.PeerPort = dht.conf.PeerProtocolPort
 		dht.node.Store(hash, c)
-		return
+		result.Status = StoreAccepted
+		return result
 	}
 
-	dht.node.SendAsync(c, Request{
+	err := dht.sendStore(hash, c)
+	if isInvalidToken(err) {
+		dht.tokenCache.Invalidate(c)
+		result.TokenRefreshed = true
+		err = dht.sendStore(hash, c)
+	}
+
+	result.Err = err
+	switch {
+	case err == nil:
+		result.Status = StoreAccepted
+	case isInvalidToken(err):
+		result.Status = StoreInvalidToken
+	case errors.Is(err, ErrRequestTimeout):
+		result.Status = StoreTimeout
+	default:
+		result.Status = StoreFailed
+	}
+	return result
+}
+
+func (dht *DHT) sendStore(hash bits.Bitmap, c Contact) error {
+	res, err := dht.node.sendRequest(dht.grp.Ctx(), c, Request{
 		Method: storeMethod,
 		StoreArgs: &storeArgs{
 			BlobHash: hash,
@@ -209,6 +410,38 @@ func (dht *DHT) store(hash bits.Bitmap, c Contact) {
 				LbryID: dht.contact.ID,
 				Port:   dht.conf.PeerProtocolPort,
 			},
+			NodeID: dht.contact.ID,
 		},
 	})
+	if err != nil {
+		return err
+	}
+	if res.Data != storeSuccessResponse {
+		return errors.Err("unexpected store response: %s", res.Data)
+	}
+	return nil
+}
+
+func isInvalidToken(err error) bool {
+	remoteErr, ok := err.(*RemoteError)
+	return ok && remoteErr.ExceptionType == invalidTokenError
+}
+
+// VerifyAnnounce looks up the peers for the hash and returns true if this node is one of them, which confirms that
+// the hash was announced and can be found by others.
+func (dht *DHT) VerifyAnnounce(ctx context.Context, hash bits.Bitmap) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var found atomic.Bool
+	_, err := FindPeersStream(ctx, dht.node, hash, 0, dht.grp.Child(), func(c Contact) {
+		if c.ID.Equals(dht.contact.ID) {
+			found.Store(true)
+			cancel()
+		}
+	})
+	if found.Load() {
+		return true, nil
+	}
+	return false, err
 }
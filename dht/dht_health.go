@@ -0,0 +1,127 @@
+package dht
+
+import (
+	"sync"
+	"time"
+)
+
+// maxRejoinBackoff is the longest the health check waits between attempts to rejoin the network
+const maxRejoinBackoff = 30 * time.Minute
+
+// JoinState is whether a node is connected to the rest of the network
+type JoinState string
+
+const (
+	// JoinStateJoining means the node has not finished joining the network yet
+	JoinStateJoining JoinState = "joining"
+	// JoinStateJoined means at least one other node responded the last time the node joined the network
+	JoinStateJoined JoinState = "joined"
+	// JoinStateIsolated means no other nodes responded the last time the node tried to join the network
+	JoinStateIsolated JoinState = "isolated"
+)
+
+// JoinStatus describes how well a node is connected to the network
+type JoinStatus struct {
+	State JoinState
+	// when the node entered the current state
+	Since time.Time
+	// the number of contacts in the routing table
+	Contacts int
+	// the number of times the health check rejoined the network because the routing table had too few contacts
+	Rejoins int
+	// the number of rejoins in a row in which no nodes responded
+	FailedAttempts int
+	// when the health check will try to rejoin again. zero if the routing table has enough contacts
+	NextAttempt time.Time
+}
+
+// joinHealth tracks the join state of a DHT
+type joinHealth struct {
+	mu     sync.RWMutex
+	status JoinStatus
+}
+
+func newJoinHealth() *joinHealth {
+	return &joinHealth{status: JoinStatus{State: JoinStateJoining, Since: time.Now()}}
+}
+
+// JoinStatus returns the join state of the node. Unlike WaitUntilJoined, it keeps changing after the first join.
+func (dht *DHT) JoinStatus() JoinStatus {
+	dht.health.mu.RLock()
+	s := dht.health.status
+	dht.health.mu.RUnlock()
+
+	if dht.node != nil {
+		s.Contacts = dht.node.rt.Count()
+	}
+	return s
+}
+
+func (dht *DHT) setJoinState(state JoinState) {
+	dht.health.mu.Lock()
+	defer dht.health.mu.Unlock()
+	if dht.health.status.State != state {
+		dht.health.status.State = state
+		dht.health.status.Since = time.Now()
+	}
+}
+
+// runHealthCheck periodically checks the routing table. If it has fewer than MinContacts contacts, the node pings the
+// seed nodes and looks itself up again, as if it was joining for the first time. Failed attempts are retried with
+// exponential backoff.
+func (dht *DHT) runHealthCheck() {
+	interval := dht.node.conf.HealthCheckInterval
+	wait := interval
+
+	for {
+		select {
+		case <-dht.grp.Ch():
+			return
+		case <-time.After(wait):
+		}
+
+		if dht.node.rt.Count() >= dht.node.conf.MinContacts {
+			wait = interval
+			dht.health.mu.Lock()
+			dht.health.status.FailedAttempts = 0
+			dht.health.status.NextAttempt = time.Time{}
+			dht.health.mu.Unlock()
+			continue
+		}
+
+		log.Infof("[%s] health: routing table has %d contacts, rejoining network",
+			dht.node.id.HexShort(), dht.node.rt.Count())
+
+		joined := dht.Rejoin()
+		if joined && dht.node.rt.Count() >= dht.node.conf.MinContacts {
+			wait = interval
+		} else {
+			wait *= 2
+			if wait > maxRejoinBackoff {
+				wait = maxRejoinBackoff
+			}
+		}
+
+		dht.health.mu.Lock()
+		dht.health.status.Rejoins++
+		if joined {
+			dht.health.status.FailedAttempts = 0
+		} else {
+			dht.health.status.FailedAttempts++
+		}
+		dht.health.status.NextAttempt = time.Now().Add(wait)
+		dht.health.mu.Unlock()
+	}
+}
+
+// Rejoin pings the seed nodes and looks up this node's own ID again, as if it was joining for the first time. It
+// returns false if no nodes responded.
+func (dht *DHT) Rejoin() bool {
+	joined := dht.joinNetwork(nil)
+	if joined {
+		dht.setJoinState(JoinStateJoined)
+	} else {
+		dht.setJoinState(JoinStateIsolated)
+	}
+	return joined
+}
@@ -0,0 +1,106 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+func waitForJoinStatus(t *testing.T, d *DHT, timeout time.Duration, ok func(JoinStatus) bool) JoinStatus {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		s := d.JoinStatus()
+		if ok(s) {
+			return s
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for join status, last status was %+v", s)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestDHT_HealthCheckRejoins(t *testing.T) {
+	conf := NewStandardConfig()
+	conf.HealthCheckInterval = 100 * time.Millisecond
+	conf.MinContacts = 2
+
+	bs, dhts := TestingCreateNetworkWithConfig(t, 2, true, false, conf)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	d := dhts[1]
+	if s := d.JoinStatus(); s.State != JoinStateJoined {
+		t.Fatalf("expected node to be joined, got %s", s.State)
+	}
+
+	// lose every contact, as if the node was offline for a while
+	d.node.rt.reset()
+
+	s := waitForJoinStatus(t, d, 20*time.Second, func(s JoinStatus) bool {
+		return s.Rejoins > 0 && s.Contacts >= conf.MinContacts
+	})
+	if s.State != JoinStateJoined {
+		t.Errorf("expected node to be joined after rejoining, got %s", s.State)
+	}
+	if s.FailedAttempts != 0 {
+		t.Errorf("expected no failed attempts, got %d", s.FailedAttempts)
+	}
+}
+
+func TestDHT_HealthCheckIsolated(t *testing.T) {
+	seedAddr := "127.0.0.1:21013"
+	d := New(&Config{
+		Address:             "127.0.0.1:21012",
+		NodeID:              bits.Rand().Hex(),
+		SeedNodes:           []string{seedAddr},
+		UDPTimeout:          200 * time.Millisecond,
+		HealthCheckInterval: 100 * time.Millisecond,
+		MinContacts:         1,
+	})
+	if s := d.JoinStatus(); s.State != JoinStateJoining {
+		t.Errorf("expected new node to be joining, got %s", s.State)
+	}
+
+	err := d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Shutdown()
+
+	if s := d.JoinStatus(); s.State != JoinStateIsolated {
+		t.Fatalf("expected node with no reachable seeds to be isolated, got %s", s.State)
+	}
+
+	s := waitForJoinStatus(t, d, 5*time.Second, func(s JoinStatus) bool { return s.FailedAttempts >= 2 })
+	if s.State != JoinStateIsolated || s.NextAttempt.IsZero() {
+		t.Errorf("expected node to stay isolated and schedule another attempt, got %+v", s)
+	}
+
+	// now the seed comes online
+	bs := NewBootstrapNode(bits.Rand(), 0, bootstrapDefaultRefreshDuration)
+	listener, err := net.ListenPacket(Network, seedAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = bs.Connect(listener.(*net.UDPConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bs.Shutdown()
+
+	s = waitForJoinStatus(t, d, 20*time.Second, func(s JoinStatus) bool { return s.State == JoinStateJoined })
+	if s.FailedAttempts != 0 {
+		t.Errorf("expected failed attempts to be reset, got %d", s.FailedAttempts)
+	}
+	if s.Contacts < 1 {
+		t.Errorf("expected the seed in the routing table, got %d contacts", s.Contacts)
+	}
+}
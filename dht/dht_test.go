@@ -1,12 +1,17 @@
 package dht
 
 import (
+	"context"
 	"net"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/store"
+	"github.com/lbryio/lbry.go/v2/stream"
 )
 
 func TestNodeFinder_FindNodes(t *testing.T) {
@@ -91,7 +96,7 @@ func TestNodeFinder_FindValue(t *testing.T) {
 
 	blobHashToFind := bits.Rand()
 	nodeToFind := Contact{ID: bits.Rand(), IP: net.IPv4(1, 2, 3, 4), Port: 5678}
-	dhts[0].node.store.Upsert(blobHashToFind, nodeToFind)
+	dhts[0].node.Store(blobHashToFind, nodeToFind)
 
 	contacts, found, err := FindContacts(dhts[2].node, blobHashToFind, true, nil)
 	if err != nil {
@@ -111,6 +116,48 @@ func TestNodeFinder_FindValue(t *testing.T) {
 	}
 }
 
+func TestNodeFinder_FindValuePages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow nodeFinder test")
+	}
+
+	bs, dhts := TestingCreateNetwork(t, 3, true, false)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	// more peers than fit on one page, so the finder has to ask for the rest
+	hash := bits.Rand()
+	stored := make(map[bits.Bitmap]bool)
+	for i := 0; i < 3*defaultBucketSize; i++ {
+		c := Contact{ID: bits.Rand(), IP: net.IPv4(1, 2, 3, 4), PeerPort: 5000 + i}
+		dhts[0].node.Store(hash, c)
+		stored[c.ID] = true
+	}
+
+	contacts, found, err := FindContacts(dhts[2].node, hash, true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("hash was not found")
+	}
+
+	seen := make(map[bits.Bitmap]bool)
+	for _, c := range contacts {
+		if !stored[c.ID] {
+			t.Errorf("found %s, which was not stored", c.ID.HexShort())
+		}
+		seen[c.ID] = true
+	}
+	if len(seen) != len(stored) {
+		t.Errorf("expected %d peers, found %d", len(stored), len(seen))
+	}
+}
+
 func TestDHT_LargeDHT(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping large DHT test")
@@ -133,7 +180,7 @@ func TestDHT_LargeDHT(t *testing.T) {
 		wg.Add(1)
 		go func(index int) {
 			defer wg.Done()
-			err := dhts[index].announce(ids[index])
+			_, err := dhts[index].Announce(ids[index])
 			if err != nil {
 				t.Error("error announcing random bitmap - ", err)
 			}
@@ -167,7 +214,7 @@ func TestDHT_LargeDHT(t *testing.T) {
 	storeCounts := make(map[bits.Bitmap]int)
 	for _, d := range dhts {
 		for _, id := range ids {
-			if len(d.node.store.Get(id)) > 0 {
+			if len(mustGet(t, d.node.store, id)) > 0 {
 				storeCounts[id]++
 			}
 		}
@@ -179,3 +226,394 @@ func TestDHT_LargeDHT(t *testing.T) {
 		}
 	}
 }
+
+func TestDHT_BlobSource(t *testing.T) {
+	blobs := store.NewNotifyingStore(store.NewMemoryStore())
+	existing := stream.Blob("already stored before start")
+	err := blobs.Put(existing.HashHex(), existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewStandardConfig()
+	c.Address = testingDHTIP + ":" + strconv.Itoa(testingDHTFirstPort+11)
+	c.SeedNodes = nil
+	c.BlobSource = blobs
+	d := New(c)
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Shutdown()
+
+	waitForAnnounced := func(expected ...stream.Blob) {
+		t.Helper()
+		var hashes []bits.Bitmap
+		for i := 0; i < 20; i++ {
+			hashes = d.announcedHashes()
+			if len(hashes) == len(expected) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(hashes) != len(expected) {
+			t.Fatalf("expected %d announced hashes, got %d", len(expected), len(hashes))
+		}
+		for _, b := range expected {
+			found := false
+			for _, h := range hashes {
+				if h.Hex() == b.HashHex() {
+					found = true
+				}
+			}
+			if !found {
+				t.Errorf("blob %s is not being announced", b.HashHex())
+			}
+		}
+	}
+
+	waitForAnnounced(existing)
+
+	added := stream.Blob("stored after start")
+	err = blobs.Put(added.HashHex(), added)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForAnnounced(existing, added)
+
+	err = blobs.Delete(existing.HashHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForAnnounced(added)
+}
+
+// listenCountingSource counts the listeners registered with the store
+type listenCountingSource struct {
+	*store.NotifyingStore
+	listening int32
+}
+
+func (s *listenCountingSource) Listen(fn func(hash string, added bool)) func() {
+	atomic.AddInt32(&s.listening, 1)
+	unlisten := s.NotifyingStore.Listen(fn)
+	return func() {
+		atomic.AddInt32(&s.listening, -1)
+		unlisten()
+	}
+}
+
+func TestDHT_BlobSourceDoesNotBlock(t *testing.T) {
+	blobs := &listenCountingSource{NotifyingStore: store.NewNotifyingStore(store.NewMemoryStore())}
+
+	d := New(&Config{Address: "127.0.0.1:21216", NodeID: bits.Rand().Hex()})
+	err := d.connect(newTestUDPConn("127.0.0.1:21217"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the announcer is not running, so nothing takes the edits off the queue
+	err = d.followBlobSource(blobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 10; i++ {
+			b := stream.Blob("blob " + strconv.Itoa(i))
+			err := blobs.Put(b.HashHex(), b)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("storing blobs waited for the announcer")
+	}
+
+	d.Shutdown()
+	if n := atomic.LoadInt32(&blobs.listening); n != 0 {
+		t.Errorf("expected the listener to be unregistered on shutdown, %d still listening", n)
+	}
+}
+
+func TestDHT_GetContext(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow get test")
+	}
+
+	bs, dhts := TestingCreateNetwork(t, 2, true, false)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := dhts[0].GetContext(ctx, bits.Rand())
+	if err == nil {
+		t.Error("expected an error for a canceled lookup")
+	}
+	if time.Since(start) > 1*time.Second {
+		t.Errorf("canceled lookup took %s", time.Since(start))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	err = dhts[0].PingContext(ctx, "127.0.0.1:1") // nothing listens there
+	if err == nil {
+		t.Error("expected an error for a ping that times out")
+	}
+	if time.Since(start) > 1*time.Second {
+		t.Errorf("ping with a timeout took %s", time.Since(start))
+	}
+
+	_, err = dhts[0].Get(bits.Rand())
+	if err != nil {
+		t.Errorf("lookup without a context failed: %v", err)
+	}
+}
+
+func TestDHT_NonDefaultConfig(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow network test")
+	}
+
+	conf := NewStandardConfig()
+	conf.Alpha = 2
+	conf.BucketSize = 3
+	conf.UDPTimeout = 1 * time.Second
+	conf.UDPRetry = 2
+
+	bs, dhts := TestingCreateNetworkWithConfig(t, 6, true, false, conf)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	for _, d := range dhts {
+		if d.node.conf.BucketSize != 3 || d.node.conf.Alpha != 2 {
+			t.Fatal("node did not get the network config")
+		}
+		for _, n := range d.node.rt.BucketCounts() {
+			if n > 3 {
+				t.Errorf("bucket has %d contacts, but bucket size is 3", n)
+			}
+		}
+	}
+
+	contacts, _, err := FindContacts(dhts[0].node, dhts[5].node.id, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) > 3 {
+		t.Errorf("lookup returned %d contacts, but bucket size is 3", len(contacts))
+	}
+	if len(contacts) == 0 || !contacts[0].ID.Equals(dhts[5].node.id) {
+		t.Error("lookup did not find the target node")
+	}
+}
+
+func TestDHT_AnnounceDefaults(t *testing.T) {
+	// a config that was not made with NewStandardConfig still announces at the default rate
+	d := New(&Config{})
+	if d.scheduler.capacity != DefaultAnnounceRate*int(DefaultReannounceTime.Seconds()) {
+		t.Errorf("expected the announce capacity to use the defaults, got %d", d.scheduler.capacity)
+	}
+
+	c := &Config{}
+	c.setDefaults()
+	if c.AnnounceRate != DefaultAnnounceRate || c.ReannounceTime != DefaultReannounceTime {
+		t.Errorf("expected announce rate %d and reannounce time %s, got %d and %s",
+			DefaultAnnounceRate, DefaultReannounceTime, c.AnnounceRate, c.ReannounceTime)
+	}
+}
+
+func TestDHT_GetStream(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow get stream test")
+	}
+
+	bs, dhts := TestingCreateNetwork(t, 6, true, false)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	hash := bits.Rand()
+	var peers []Contact
+	for i := 0; i < 4; i++ {
+		peers = append(peers, Contact{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), PeerPort: 5000 + i})
+	}
+
+	// each of these nodes knows about a different peer, and the last peer is known by two nodes. the last node to join
+	// knows about all the others, so it does the search
+	for i := 0; i < 3; i++ {
+		dhts[i].node.Store(hash, peers[i])
+	}
+	dhts[3].node.Store(hash, peers[3])
+	dhts[4].node.Store(hash, peers[3])
+
+	collect := func(count int) []Contact {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		var found []Contact
+		for c := range dhts[5].GetStream(ctx, hash, count) {
+			found = append(found, c)
+		}
+		return found
+	}
+
+	found := collect(2)
+	if len(found) != 2 {
+		t.Errorf("expected 2 peers, got %d", len(found))
+	}
+
+	found = collect(0)
+	if len(found) == 0 {
+		t.Fatal("expected to find peers")
+	}
+	seen := make(map[string]bool)
+	for _, c := range found {
+		if seen[c.String()] {
+			t.Errorf("peer %s was sent twice", c.String())
+		}
+		seen[c.String()] = true
+	}
+}
+
+func TestNodeFinder_SlowOnPeer(t *testing.T) {
+	release := make(chan struct{})
+	called := make(chan struct{}, 2)
+	var got []Contact
+
+	cf := newContactFinder(NewNode(bits.Rand()), bits.Rand(), true, nil)
+	cf.seenPeers = make(map[string]bool)
+	cf.peerReady = make(chan struct{}, 1)
+	cf.onPeer = func(c Contact) {
+		got = append(got, c)
+		called <- struct{}{}
+		<-release
+	}
+	defer cf.Stop()
+
+	searchDone := make(chan struct{})
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		cf.deliverPeers(searchDone)
+	}()
+
+	peers := []Contact{
+		{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), PeerPort: 5000},
+		{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), PeerPort: 5001},
+	}
+	cf.addPeers(peers[:1])
+	<-called
+
+	// onPeer is stuck on the first peer. the search must be able to keep going anyway
+	added := make(chan struct{})
+	go func() {
+		cf.addPeers(peers[1:])
+		cf.isSearchFinished()
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a slow onPeer blocked the search")
+	}
+
+	close(release)
+	close(searchDone)
+	<-delivered
+	if len(got) != 2 || !got[0].Equals(peers[0], true) || !got[1].Equals(peers[1], true) {
+		t.Errorf("expected both peers in order, got %v", got)
+	}
+}
+
+func TestDHT_AnnounceResult(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow announce test")
+	}
+
+	conf := NewStandardConfig()
+	conf.UDPTimeout = 1 * time.Second
+
+	bs, dhts := TestingCreateNetworkWithConfig(t, 2, true, false, conf)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	d, other := dhts[1], dhts[0]
+
+	// a stale token, like the ones cached before a node restarts
+	d.tokenCache.tokens[other.contact.String()] = tokenCacheEntry{token: "stale", receivedAt: time.Now()}
+
+	hash := bits.Rand()
+	result, err := d.Announce(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var otherResult *StoreResult
+	for i, s := range result.Stores {
+		if s.Contact.ID.Equals(other.node.id) {
+			otherResult = &result.Stores[i]
+		}
+	}
+	if otherResult == nil {
+		t.Fatal("announce did not store to the other node")
+	}
+	if otherResult.Status != StoreAccepted || otherResult.Err != nil {
+		t.Errorf("expected store to be accepted, got %s (%v)", otherResult.Status, otherResult.Err)
+	}
+	if !otherResult.TokenRefreshed {
+		t.Error("expected the stale token to be refreshed")
+	}
+	if result.StoredTo() < 2 {
+		t.Errorf("expected the hash to be stored on at least 2 nodes, got %d", result.StoredTo())
+	}
+
+	found, err := d.VerifyAnnounce(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Error("announced hash should be verified")
+	}
+
+	found, err = d.VerifyAnnounce(context.Background(), bits.Rand())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("hash that was not announced should not be verified")
+	}
+
+	// nobody is listening at this address
+	missing := Contact{ID: bits.Rand(), IP: net.ParseIP(testingDHTIP), Port: testingDHTFirstPort + 99}
+	if s := d.store(hash, missing); s.Status != StoreTimeout {
+		t.Errorf("expected store to a missing node to time out, got %s (%v)", s.Status, s.Err)
+	}
+}
@@ -0,0 +1,241 @@
+package dht
+
+import (
+	"math"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+
+	"golang.org/x/time/rate"
+)
+
+// reasons a packet is dropped, passed to Metrics
+const (
+	// DropBlocked is for packets from a blocked IP
+	DropBlocked = "blocked"
+	// DropRateLimited is for requests from an IP that is sending more than RequestRate requests per second
+	DropRateLimited = "rate_limited"
+	// DropStoreLimit is for store requests that would go over MaxPeersPerHash or MaxStoresPerIP
+	DropStoreLimit = "store_limit"
+)
+
+// ErrStoreLimit is returned when a peer is not stored because the hash or the peer's IP has too many stored peers
+var ErrStoreLimit = errors.Base("store limit reached")
+
+// requestLimiter is a token bucket for each source IP
+type requestLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu  sync.Mutex
+	ips map[string]*rate.Limiter
+}
+
+// newRequestLimiter returns a limiter that lets each IP send perSecond requests per second, with bursts of up to burst
+// requests. It returns nil if perSecond is not positive. A nil limiter allows everything.
+func newRequestLimiter(perSecond float64, burst int) *requestLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &requestLimiter{
+		limit: rate.Limit(perSecond),
+		burst: burst,
+		ips:   make(map[string]*rate.Limiter),
+	}
+}
+
+// Allow takes a token from the bucket of the ip, and returns false if the bucket is empty
+func (l *requestLimiter) Allow(ip net.IP) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := ip.String()
+	limiter, ok := l.ips[key]
+	if !ok {
+		limiter = rate.NewLimiter(l.limit, l.burst)
+		l.ips[key] = limiter
+	}
+	return limiter.Allow()
+}
+
+// RemoveIdle forgets IPs whose buckets have filled up again, since a new bucket would behave the same. It returns the
+// number of IPs removed.
+func (l *requestLimiter) RemoveIdle() int {
+	if l == nil {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed := 0
+	for key, limiter := range l.ips {
+		if limiter.Tokens() >= float64(l.burst) {
+			delete(l.ips, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Len returns the number of IPs being tracked
+func (l *requestLimiter) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.ips)
+}
+
+// defaultBurst is the burst used when RequestBurst is not set. it is one second's worth of requests
+func defaultBurst(perSecond float64) int {
+	return int(math.Max(1, math.Ceil(perSecond)))
+}
+
+// blocklist is a set of IP ranges whose packets are ignored
+type blocklist struct {
+	mu   sync.RWMutex
+	nets map[string]*net.IPNet
+}
+
+func newBlocklist() *blocklist {
+	return &blocklist{nets: make(map[string]*net.IPNet)}
+}
+
+// parseIPNet parses an IP or a CIDR range. A single IP is a range with one address in it.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errors.Err("invalid IP or CIDR range: %s", s)
+	}
+	size := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, size = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(size, size)}, nil
+}
+
+func (b *blocklist) Add(s string) error {
+	ipNet, err := parseIPNet(s)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nets[ipNet.String()] = ipNet
+	return nil
+}
+
+// Remove removes an IP or range that was added before. It returns false if it was not in the blocklist.
+func (b *blocklist) Remove(s string) (bool, error) {
+	ipNet, err := parseIPNet(s)
+	if err != nil {
+		return false, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.nets[ipNet.String()]
+	delete(b.nets, ipNet.String())
+	return ok, nil
+}
+
+func (b *blocklist) Contains(ip net.IP) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, ipNet := range b.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// List returns the blocked ranges in CIDR notation
+func (b *blocklist) List() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	list := make([]string, 0, len(b.nets))
+	for s := range b.nets {
+		list = append(list, s)
+	}
+	return list
+}
+
+// Block drops all packets from an IP or CIDR range
+func (n *Node) Block(ipOrRange string) error {
+	return n.blocklist.Add(ipOrRange)
+}
+
+// Unblock removes an IP or CIDR range from the blocklist. It returns false if it was not blocked.
+func (n *Node) Unblock(ipOrRange string) (bool, error) {
+	return n.blocklist.Remove(ipOrRange)
+}
+
+// Blocklist returns the blocked IP ranges in CIDR notation
+func (n *Node) Blocklist() []string {
+	return n.blocklist.List()
+}
+
+// dropped counts a dropped packet
+func (n *Node) dropped(reason string) {
+	n.dropsMu.Lock()
+	n.drops[reason]++
+	n.dropsMu.Unlock()
+	if m, ok := n.conf.Metrics.(DropMetrics); ok {
+		m.PacketDropped(reason)
+	}
+}
+
+// droppedCounts returns the number of packets dropped for each reason
+func (n *Node) droppedCounts() map[string]int {
+	n.dropsMu.Lock()
+	defer n.dropsMu.Unlock()
+	counts := make(map[string]int, len(n.drops))
+	for reason, count := range n.drops {
+		counts[reason] = count
+	}
+	return counts
+}
+
+// errNotStarted is returned by methods that need the node to be running
+var errNotStarted = errors.Base("dht is not started")
+
+// Block drops all packets from an IP or CIDR range, such as "1.2.3.4" or "1.2.3.0/24". Ranges in Config.Blocklist are
+// blocked when the DHT starts.
+func (dht *DHT) Block(ipOrRange string) error {
+	if dht.node == nil {
+		return errors.Err(errNotStarted)
+	}
+	return dht.node.Block(ipOrRange)
+}
+
+// Unblock removes an IP or CIDR range from the blocklist. It returns false if it was not blocked.
+func (dht *DHT) Unblock(ipOrRange string) (bool, error) {
+	if dht.node == nil {
+		return false, errors.Err(errNotStarted)
+	}
+	return dht.node.Unblock(ipOrRange)
+}
+
+// Blocklist returns the blocked IP ranges in CIDR notation
+func (dht *DHT) Blocklist() []string {
+	if dht.node == nil {
+		return nil
+	}
+	return dht.node.Blocklist()
+}
@@ -0,0 +1,155 @@
+package dht
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+
+	"github.com/lyoshenka/bencode"
+)
+
+func TestRequestLimiter(t *testing.T) {
+	l := newRequestLimiter(1, 2)
+	a, b := net.ParseIP("1.2.3.4"), net.ParseIP("5.6.7.8")
+
+	if !l.Allow(a) || !l.Allow(a) {
+		t.Error("expected the burst to be allowed")
+	}
+	if l.Allow(a) {
+		t.Error("expected requests past the burst to be limited")
+	}
+	if !l.Allow(b) {
+		t.Error("IPs should be limited separately")
+	}
+
+	if removed := l.RemoveIdle(); removed != 0 {
+		t.Errorf("expected no idle IPs, removed %d", removed)
+	}
+
+	var unlimited *requestLimiter
+	if newRequestLimiter(0, 10) != unlimited {
+		t.Error("expected no limiter when the rate is zero")
+	}
+	if !unlimited.Allow(a) {
+		t.Error("a nil limiter should allow everything")
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	b := newBlocklist()
+	for _, s := range []string{"1.2.3.4", "10.0.0.0/8", "2001:db8::/32"} {
+		if err := b.Add(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Add("not an ip"); err == nil {
+		t.Error("expected an error for an invalid entry")
+	}
+
+	blocked := []string{"1.2.3.4", "10.20.30.40", "::ffff:10.1.1.1", "2001:db8::1"}
+	allowed := []string{"1.2.3.5", "11.0.0.1", "2001:db9::1"}
+	for _, ip := range blocked {
+		if !b.Contains(net.ParseIP(ip)) {
+			t.Errorf("expected %s to be blocked", ip)
+		}
+	}
+	for _, ip := range allowed {
+		if b.Contains(net.ParseIP(ip)) {
+			t.Errorf("expected %s not to be blocked", ip)
+		}
+	}
+
+	if removed, err := b.Remove("1.2.3.4/32"); err != nil || !removed {
+		t.Errorf("expected the IP to be removed, got %t %v", removed, err)
+	}
+	if b.Contains(net.ParseIP("1.2.3.4")) {
+		t.Error("expected the IP to be unblocked")
+	}
+	if len(b.List()) != 2 {
+		t.Errorf("expected 2 blocked ranges, got %v", b.List())
+	}
+}
+
+func TestNode_AbuseProtection(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+	dht := New(&Config{
+		Address:         "127.0.0.1:21216",
+		NodeID:          bits.Rand().Hex(),
+		RequestRate:     0.001,
+		RequestBurst:    4,
+		MaxPeersPerHash: 1,
+	})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	send := func(req Request) []byte {
+		t.Helper()
+		data, err := bencode.EncodeBytes(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.toRead <- testUDPPacket{addr: conn.addr, data: data}
+		select {
+		case w := <-conn.writes:
+			return w.data
+		case <-time.After(500 * time.Millisecond):
+			return nil
+		}
+	}
+
+	hash := bits.Rand()
+	store := func(id bits.Bitmap) Request {
+		return Request{ID: newMessageID(), NodeID: id, Method: storeMethod, StoreArgs: &storeArgs{
+			BlobHash: hash,
+			Value:    storeArgsValue{Token: dht.node.tokens.Get(id, conn.addr), LbryID: id, Port: 3333},
+			NodeID:   id,
+		}}
+	}
+
+	if send(store(bits.Rand())) == nil {
+		t.Fatal("expected a response to the first store")
+	}
+
+	// the hash is full, so a different peer can't be stored for it
+	data := send(store(bits.Rand()))
+	var e Error
+	if err := bencode.DecodeBytes(data, &e); err != nil || e.ExceptionType != storeLimitError {
+		t.Errorf("expected a store-limit error, got %q (%v)", e.ExceptionType, err)
+	}
+	if len(mustGet(t, dht.node.store, hash)) != 1 {
+		t.Error("expected only one peer to be stored")
+	}
+
+	err = dht.Block("127.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if send(Request{ID: newMessageID(), NodeID: bits.Rand(), Method: pingMethod}) != nil {
+		t.Error("expected no response to a blocked IP")
+	}
+	if removed, err := dht.Unblock("127.0.0.0/8"); err != nil || !removed {
+		t.Fatalf("expected the range to be unblocked, got %t %v", removed, err)
+	}
+
+	// two of the four requests in the burst are used up by the stores
+	for i := 0; i < 2; i++ {
+		if send(Request{ID: newMessageID(), NodeID: bits.Rand(), Method: pingMethod}) == nil {
+			t.Fatalf("expected a response to ping %d", i)
+		}
+	}
+	if send(Request{ID: newMessageID(), NodeID: bits.Rand(), Method: pingMethod}) != nil {
+		t.Error("expected the ping to be rate limited")
+	}
+
+	dropped := dht.Stats().DroppedPackets
+	for reason, expected := range map[string]int{DropStoreLimit: 1, DropBlocked: 1, DropRateLimited: 1} {
+		if dropped[reason] != expected {
+			t.Errorf("expected %d packets dropped as %s, got %d", expected, reason, dropped[reason])
+		}
+	}
+}
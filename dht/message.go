@@ -21,11 +21,24 @@ const (
 	findValueMethod = "findValue"
 )
 
+// the request methods, for sending requests with Node.Send from outside this package
+const (
+	PingMethod      = pingMethod
+	StoreMethod     = storeMethod
+	FindNodeMethod  = findNodeMethod
+	FindValueMethod = findValueMethod
+)
+
 const (
 	pingSuccessResponse  = "pong"
 	storeSuccessResponse = "OK"
 )
 
+const (
+	invalidTokenError = "invalid-token"
+	storeLimitError   = "store-limit"
+)
+
 const (
 	requestType  = 0
 	responseType = 1
@@ -91,6 +104,8 @@ type Request struct {
 	Arg             *bits.Bitmap
 	StoreArgs       *storeArgs
 	ProtocolVersion int
+	// the page of peers wanted from a findValue. pages start at 0
+	Page int
 }
 
 // MarshalBencode returns the serialized byte slice representation of the request
@@ -98,10 +113,23 @@ func (r Request) MarshalBencode() ([]byte, error) {
 	var args interface{}
 	if r.StoreArgs != nil {
 		args = r.StoreArgs
-	} else if r.Arg != nil {
-		args = []bits.Bitmap{*r.Arg}
 	} else {
-		args = []string{} // request must always have keys 0-4, so we use an empty list for PING
+		// request must always have keys 0-4, so PING gets an empty list
+		list := []interface{}{}
+		if r.Arg != nil {
+			list = append(list, *r.Arg)
+		}
+		extras := map[string]int{}
+		if r.ProtocolVersion > 0 {
+			extras[protocolVersionField] = r.ProtocolVersion
+		}
+		if r.Page > 0 {
+			extras[pageField] = r.Page
+		}
+		if len(extras) > 0 {
+			list = append(list, extras)
+		}
+		args = list
 	}
 	return bencode.EncodeBytes(map[string]interface{}{
 		headerTypeField:      requestType,
@@ -136,7 +164,7 @@ func (r *Request) UnmarshalBencode(b []byte) error {
 			return errors.Prefix("request unmarshal", err)
 		}
 	} else if len(raw.Args) > 2 { // 2 because an empty list is `le`
-		r.Arg, r.ProtocolVersion, err = processArgsAndProtoVersion(raw.Args)
+		r.Arg, r.ProtocolVersion, r.Page, err = processArgsAndProtoVersion(raw.Args)
 		if err != nil {
 			return errors.Prefix("request unmarshal", err)
 		}
@@ -145,22 +173,26 @@ func (r *Request) UnmarshalBencode(b []byte) error {
 	return nil
 }
 
-func processArgsAndProtoVersion(raw bencode.RawMessage) (arg *bits.Bitmap, version int, err error) {
+// processArgsAndProtoVersion decodes the args of a request. the last arg may be a dict with the protocol version and
+// the page of results wanted, like `[key, {"protocolVersion": 1, "p": 2}]`
+func processArgsAndProtoVersion(raw bencode.RawMessage) (arg *bits.Bitmap, version, page int, err error) {
 	var args []bencode.RawMessage
 	err = bencode.DecodeBytes(raw, &args)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, 0, err
 	}
 
 	if len(args) == 0 {
-		return nil, 0, nil
+		return nil, 0, 0, nil
 	}
 
 	var extras map[string]int
 	err = bencode.DecodeBytes(args[len(args)-1], &extras)
 	if err == nil {
-		if v, exists := extras[protocolVersionField]; exists {
-			version = v
+		v, hasVersion := extras[protocolVersionField]
+		p, hasPage := extras[pageField]
+		if hasVersion || hasPage {
+			version, page = v, p
 			args = args[:len(args)-1]
 		}
 	}
@@ -169,12 +201,16 @@ func processArgsAndProtoVersion(raw bencode.RawMessage) (arg *bits.Bitmap, versi
 		var b bits.Bitmap
 		err = bencode.DecodeBytes(args[0], &b)
 		if err != nil {
-			return nil, 0, err
+			return nil, 0, 0, err
 		}
 		arg = &b
 	}
 
-	return arg, version, nil
+	if page < 0 {
+		return nil, 0, 0, errors.Err("invalid page %d", page)
+	}
+
+	return arg, version, page, nil
 }
 
 func (r Request) argsDebug() string {
@@ -271,7 +307,8 @@ type Response struct {
 	FindValueKey    string
 	Token           string
 	ProtocolVersion int
-	Page            uint8
+	// the number of pages of peers the responder has for a findValue key. only sent to nodes that ask for pages
+	Page uint8
 }
 
 func (r Response) argsDebug() string {
@@ -322,16 +359,19 @@ func (r Response) MarshalBencode() ([]byte, error) {
 			}
 			contacts = append(contacts, compact)
 		}
-		data[headerPayloadField] = map[string]interface{}{
+		if contacts == nil {
+			contacts = [][]byte{} // a page past the last one has no peers, but still has the key
+		}
+		data[headerPayloadField] = r.findValuePayload(map[string]interface{}{
 			r.FindValueKey: contacts,
 			tokenField:     r.Token,
-		}
+		})
 	} else if r.Token != "" {
 		// findValue failure falling back to findNode
-		data[headerPayloadField] = map[string]interface{}{
+		data[headerPayloadField] = r.findValuePayload(map[string]interface{}{
 			contactsField: r.Contacts,
 			tokenField:    r.Token,
-		}
+		})
 	} else {
 		// straight up findNode
 		data[headerPayloadField] = r.Contacts
@@ -340,6 +380,17 @@ func (r Response) MarshalBencode() ([]byte, error) {
 	return bencode.EncodeBytes(data)
 }
 
+// findValuePayload adds the page count and protocol version to a findValue payload, if they are set
+func (r Response) findValuePayload(payload map[string]interface{}) map[string]interface{} {
+	if r.Page > 0 {
+		payload[pageField] = r.Page
+	}
+	if r.ProtocolVersion > 0 {
+		payload[protocolVersionField] = r.ProtocolVersion
+	}
+	return payload
+}
+
 // UnmarshalBencode unmarshals the serialized byte slice into the appropriate fields of the store arguments.
 func (r *Response) UnmarshalBencode(b []byte) error {
 	var raw struct {
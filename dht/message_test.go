@@ -222,3 +222,98 @@ func compareResponses(t *testing.T, res, res2 Response) {
 		t.Errorf("expected FindNodeData %s, got %s", spew.Sdump(res.Contacts), spew.Sdump(res2.Contacts))
 	}
 }
+
+func TestRequestProtocolVersion(t *testing.T) {
+	target := bits.Rand()
+	for _, req := range []Request{
+		{ID: newMessageID(), NodeID: bits.Rand(), Method: findNodeMethod, Arg: &target, ProtocolVersion: protocolVersion},
+		{ID: newMessageID(), NodeID: bits.Rand(), Method: pingMethod, ProtocolVersion: protocolVersion},
+	} {
+		encoded, err := bencode.EncodeBytes(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Request
+		err = bencode.DecodeBytes(encoded, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.ProtocolVersion != protocolVersion {
+			t.Errorf("%s: expected protocol version %d, got %d", req.Method, protocolVersion, decoded.ProtocolVersion)
+		}
+		if (req.Arg == nil) != (decoded.Arg == nil) || (req.Arg != nil && !decoded.Arg.Equals(*req.Arg)) {
+			t.Errorf("%s: arg was not decoded correctly", req.Method)
+		}
+	}
+}
+
+func TestRequestPage(t *testing.T) {
+	target := bits.Rand()
+
+	// the python daemon puts the page in the same dict as the protocol version
+	raw, err := bencode.EncodeBytes(map[string]interface{}{
+		headerTypeField:      requestType,
+		headerMessageIDField: newMessageID(),
+		headerNodeIDField:    bits.Rand(),
+		headerPayloadField:   findValueMethod,
+		headerArgsField:      []interface{}{target, map[string]int{pageField: 3, protocolVersionField: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req Request
+	err = bencode.DecodeBytes(raw, &req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Page != 3 || req.ProtocolVersion != 1 || req.Arg == nil || !req.Arg.Equals(target) {
+		t.Errorf("request was not decoded correctly: page %d, version %d", req.Page, req.ProtocolVersion)
+	}
+
+	encoded, err := bencode.EncodeBytes(Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findValueMethod, Arg: &target, Page: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded Request
+	err = bencode.DecodeBytes(encoded, &decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Page != 2 || decoded.ProtocolVersion != 0 {
+		t.Errorf("expected page 2 and no protocol version, got page %d and version %d", decoded.Page, decoded.ProtocolVersion)
+	}
+}
+
+func TestResponsePage(t *testing.T) {
+	key := bits.Rand()
+	res := Response{
+		ID:              newMessageID(),
+		NodeID:          bits.Rand(),
+		FindValueKey:    key.RawString(),
+		Token:           "token",
+		ProtocolVersion: protocolVersion,
+		Page:            4,
+		Contacts:        []Contact{{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4").To4(), PeerPort: 5678}},
+	}
+
+	for _, contacts := range [][]Contact{res.Contacts, nil} {
+		res.Contacts = contacts
+		encoded, err := bencode.EncodeBytes(res)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var decoded Response
+		err = bencode.DecodeBytes(encoded, &decoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compareResponses(t, res, decoded)
+		if decoded.Page != 4 || decoded.ProtocolVersion != protocolVersion {
+			t.Errorf("expected 4 pages and version %d, got %d and %d", protocolVersion, decoded.Page, decoded.ProtocolVersion)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package dht
+
+import (
+	"time"
+)
+
+// packet kinds passed to Metrics
+const (
+	PacketRequest  = "request"
+	PacketResponse = "response"
+	PacketError    = "error"
+)
+
+// Metrics receives events from a node as they happen. Implementations must be safe for concurrent use and should
+// return quickly, since they are called while packets are being handled. The dht/metrics package has a Prometheus
+// implementation.
+type Metrics interface {
+	// PacketReceived is called for every well-formed packet the node receives. kind is one of the Packet* constants.
+	// method is the request method for requests, and empty otherwise
+	PacketReceived(kind, method string)
+	// PacketSent is called for every packet the node sends. kind and method are the same as for PacketReceived
+	PacketSent(kind, method string)
+	// RequestHandled is called after the node has handled a request, with how long that took
+	RequestHandled(method string, d time.Duration)
+	// TokenVerificationFailed is called when a store request is rejected because its token is invalid
+	TokenVerificationFailed()
+	// StoreLookup is called when the contact store is searched for peers. hit is true if any peers were found
+	StoreLookup(hit bool)
+	// AnnounceQueue is called by the announcer whenever the announce queue changes or a hash is announced. lag is how
+	// far past its reannounce time the hash being announced is
+	AnnounceQueue(length int, lag time.Duration)
+}
+
+// DropMetrics can be implemented by a Metrics to also receive the packets dropped by the abuse protection
+type DropMetrics interface {
+	// PacketDropped is called when a packet is dropped. reason is one of the Drop* constants
+	PacketDropped(reason string)
+}
+
+// noMetrics is used when the config does not have a Metrics
+type noMetrics struct{}
+
+func (noMetrics) PacketReceived(kind, method string)            {}
+func (noMetrics) PacketSent(kind, method string)                {}
+func (noMetrics) RequestHandled(method string, d time.Duration) {}
+func (noMetrics) TokenVerificationFailed()                      {}
+func (noMetrics) StoreLookup(hit bool)                          {}
+func (noMetrics) AnnounceQueue(length int, lag time.Duration)   {}
+
+// Stats is a snapshot of the state of a DHT node
+type Stats struct {
+	// the number of contacts in the routing table
+	Contacts int
+	// the number of contacts in each routing table bucket
+	Buckets []int
+	// the maximum number of contacts in a bucket
+	BucketSize int
+	// the number of requests waiting for a response
+	Transactions int
+	// the number of hashes other nodes have stored with this node
+	StoredHashes int
+	// the number of distinct peers storing those hashes
+	StoredPeers int
+	// the number of hashes this node is announcing
+	AnnouncedHashes int
+	// the number of packets dropped by the abuse protection, for each Drop* reason
+	DroppedPackets map[string]int
+	// the number of IPs tracked by the rate limiter
+	RateLimitedIPs int
+}
+
+// Stats returns a snapshot of the node's state. It returns the zero value if the DHT is not started.
+func (dht *DHT) Stats() Stats {
+	if dht.node == nil {
+		return Stats{}
+	}
+
+	s := dht.node.Stats()
+	s.AnnouncedHashes = dht.scheduler.Len()
+	return s
+}
+
+// Stats returns a snapshot of the node's state
+func (n *Node) Stats() Stats {
+	return Stats{
+		Contacts:     n.rt.Count(),
+		Buckets:      n.rt.BucketCounts(),
+		BucketSize:   n.conf.BucketSize,
+		Transactions: n.CountActiveTransactions(),
+		StoredHashes: n.store.CountStoredHashes(),
+		StoredPeers:  n.store.CountStoredPeers(),
+
+		DroppedPackets: n.droppedCounts(),
+		RateLimitedIPs: n.limiter.Len(),
+	}
+}
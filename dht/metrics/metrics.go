@@ -0,0 +1,205 @@
+// Package metrics exports the metrics of a DHT node to Prometheus.
+//
+// Create a Collector, set it as the Metrics of the dht.Config, and register it once the DHT is created:
+//
+//	c := metrics.NewCollector(nil)
+//	conf.Metrics = c
+//	d := dht.New(conf)
+//	c.Watch(d)
+//	prometheus.MustRegister(c)
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "dht"
+
+var (
+	_ dht.Metrics     = (*Collector)(nil)
+	_ dht.DropMetrics = (*Collector)(nil)
+)
+
+// Collector is a dht.Metrics and dht.DropMetrics that is also a prometheus.Collector. Events from the node are counted
+// as they happen. The state of the routing table, transactions and contact store is read from the watched DHT on every
+// scrape.
+type Collector struct {
+	packetsIn       *prometheus.CounterVec
+	packetsOut      *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	tokenFailures   prometheus.Counter
+	storeLookups    *prometheus.CounterVec
+	announceQueue   prometheus.Gauge
+	announceLag     prometheus.Gauge
+	packetsDropped  *prometheus.CounterVec
+
+	contacts        *prometheus.Desc
+	bucketContacts  *prometheus.Desc
+	bucketSize      *prometheus.Desc
+	transactions    *prometheus.Desc
+	storedHashes    *prometheus.Desc
+	storedPeers     *prometheus.Desc
+	announcedHashes *prometheus.Desc
+
+	mu  sync.RWMutex
+	dht *dht.DHT
+}
+
+// NewCollector returns a collector. The labels are added to every metric, so that several nodes in one process can be
+// told apart. labels may be nil.
+func NewCollector(labels prometheus.Labels) *Collector {
+	desc := func(name, help string, variableLabels ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, variableLabels, labels)
+	}
+
+	return &Collector{
+		packetsIn: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "packets_received_total", ConstLabels: labels,
+			Help: "Packets received, by kind (request, response, error) and request method.",
+		}, []string{"kind", "method"}),
+		packetsOut: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "packets_sent_total", ConstLabels: labels,
+			Help: "Packets sent, by kind (request, response, error) and request method.",
+		}, []string{"kind", "method"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace, Name: "request_handling_seconds", ConstLabels: labels,
+			Help:    "Time taken to handle a request, by method.",
+			Buckets: prometheus.ExponentialBuckets(0.00001, 4, 10), // 10µs to ~2.6s
+		}, []string{"method"}),
+		tokenFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace, Name: "token_verification_failures_total", ConstLabels: labels,
+			Help: "Store requests rejected because of an invalid token.",
+		}),
+		storeLookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "store_lookups_total", ConstLabels: labels,
+			Help: "Contact store lookups, by result (hit, miss).",
+		}, []string{"result"}),
+		announceQueue: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "announce_queue_length", ConstLabels: labels,
+			Help: "Hashes in the announce queue.",
+		}),
+		announceLag: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace, Name: "announce_lag_seconds", ConstLabels: labels,
+			Help: "How far past its reannounce time the most recently announced hash was.",
+		}),
+		packetsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace, Name: "packets_dropped_total", ConstLabels: labels,
+			Help: "Packets dropped by the abuse protection, by reason (blocked, rate_limited, store_limit).",
+		}, []string{"reason"}),
+
+		contacts:        desc("routing_table_contacts", "Contacts in the routing table."),
+		bucketContacts:  desc("routing_table_bucket_contacts", "Contacts in each routing table bucket.", "bucket"),
+		bucketSize:      desc("routing_table_bucket_size", "Maximum number of contacts in a bucket."),
+		transactions:    desc("active_transactions", "Requests waiting for a response."),
+		storedHashes:    desc("stored_hashes", "Hashes other nodes have stored with this node."),
+		storedPeers:     desc("stored_peers", "Distinct peers storing hashes with this node."),
+		announcedHashes: desc("announced_hashes", "Hashes this node is announcing."),
+	}
+}
+
+// Watch sets the DHT whose state is reported on each scrape. Until it is called, only event metrics are reported.
+func (c *Collector) Watch(d *dht.DHT) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.dht = d
+}
+
+// PacketReceived implements dht.Metrics
+func (c *Collector) PacketReceived(kind, method string) {
+	c.packetsIn.WithLabelValues(kind, method).Inc()
+}
+
+// PacketSent implements dht.Metrics
+func (c *Collector) PacketSent(kind, method string) {
+	c.packetsOut.WithLabelValues(kind, method).Inc()
+}
+
+// RequestHandled implements dht.Metrics
+func (c *Collector) RequestHandled(method string, d time.Duration) {
+	c.requestDuration.WithLabelValues(method).Observe(d.Seconds())
+}
+
+// TokenVerificationFailed implements dht.Metrics
+func (c *Collector) TokenVerificationFailed() {
+	c.tokenFailures.Inc()
+}
+
+// StoreLookup implements dht.Metrics
+func (c *Collector) StoreLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	c.storeLookups.WithLabelValues(result).Inc()
+}
+
+// AnnounceQueue implements dht.Metrics
+func (c *Collector) AnnounceQueue(length int, lag time.Duration) {
+	c.announceQueue.Set(float64(length))
+	c.announceLag.Set(lag.Seconds())
+}
+
+// PacketDropped implements dht.DropMetrics
+func (c *Collector) PacketDropped(reason string) {
+	c.packetsDropped.WithLabelValues(reason).Inc()
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.packetsIn.Describe(ch)
+	c.packetsOut.Describe(ch)
+	c.requestDuration.Describe(ch)
+	c.tokenFailures.Describe(ch)
+	c.storeLookups.Describe(ch)
+	c.announceQueue.Describe(ch)
+	c.announceLag.Describe(ch)
+	c.packetsDropped.Describe(ch)
+
+	ch <- c.contacts
+	ch <- c.bucketContacts
+	ch <- c.bucketSize
+	ch <- c.transactions
+	ch <- c.storedHashes
+	ch <- c.storedPeers
+	ch <- c.announcedHashes
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.packetsIn.Collect(ch)
+	c.packetsOut.Collect(ch)
+	c.requestDuration.Collect(ch)
+	c.tokenFailures.Collect(ch)
+	c.storeLookups.Collect(ch)
+	c.announceQueue.Collect(ch)
+	c.announceLag.Collect(ch)
+	c.packetsDropped.Collect(ch)
+
+	c.mu.RLock()
+	d := c.dht
+	c.mu.RUnlock()
+	if d == nil {
+		return
+	}
+
+	s := d.Stats()
+	gauge := func(desc *prometheus.Desc, v int, labelValues ...string) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), labelValues...)
+	}
+
+	gauge(c.contacts, s.Contacts)
+	for i, n := range s.Buckets {
+		gauge(c.bucketContacts, n, strconv.Itoa(i))
+	}
+	gauge(c.bucketSize, s.BucketSize)
+	gauge(c.transactions, s.Transactions)
+	gauge(c.storedHashes, s.StoredHashes)
+	gauge(c.storedPeers, s.StoredPeers)
+	gauge(c.announcedHashes, s.AnnouncedHashes)
+}
@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht"
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector_Events(t *testing.T) {
+	c := NewCollector(prometheus.Labels{"node": "test"})
+
+	c.PacketReceived(dht.PacketRequest, "ping")
+	c.PacketReceived(dht.PacketRequest, "ping")
+	c.PacketSent(dht.PacketResponse, "")
+	c.StoreLookup(true)
+	c.StoreLookup(false)
+	c.StoreLookup(false)
+	c.TokenVerificationFailed()
+	c.AnnounceQueue(7, 3*time.Second)
+
+	if v := testutil.ToFloat64(c.packetsIn.WithLabelValues(dht.PacketRequest, "ping")); v != 2 {
+		t.Errorf("expected 2 ping requests received, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.packetsOut.WithLabelValues(dht.PacketResponse, "")); v != 1 {
+		t.Errorf("expected 1 response sent, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.storeLookups.WithLabelValues("miss")); v != 2 {
+		t.Errorf("expected 2 store misses, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.tokenFailures); v != 1 {
+		t.Errorf("expected 1 token failure, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.announceQueue); v != 7 {
+		t.Errorf("expected announce queue length 7, got %v", v)
+	}
+	if v := testutil.ToFloat64(c.announceLag); v != 3 {
+		t.Errorf("expected announce lag 3s, got %v", v)
+	}
+
+	// nothing is watched yet, so only the event metrics are reported
+	lint(t, c)
+}
+
+func TestCollector_DHT(t *testing.T) {
+	seedCollector := NewCollector(nil)
+	seedConf := dht.NewStandardConfig()
+	seedConf.Address = "127.0.0.1:21310"
+	seedConf.SeedNodes = nil
+	seedConf.Metrics = seedCollector
+	seed := dht.New(seedConf)
+	seedCollector.Watch(seed)
+
+	err := seed.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seed.Shutdown()
+
+	nodeCollector := NewCollector(nil)
+	nodeConf := dht.NewStandardConfig()
+	nodeConf.Address = "127.0.0.1:21311"
+	nodeConf.SeedNodes = []string{seedConf.Address}
+	nodeConf.Metrics = nodeCollector
+	node := dht.New(nodeConf)
+	nodeCollector.Watch(node)
+
+	err = node.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Shutdown()
+
+	node.Add(bits.Rand())
+
+	// wait for the announce to be stored on the seed. the lookup before the store can take a full lookup cycle
+	deadline := time.Now().Add(15 * time.Second)
+	for seed.Stats().StoredHashes == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("hash was not stored on the seed node")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if v := testutil.ToFloat64(seedCollector.packetsIn.WithLabelValues(dht.PacketRequest, "ping")); v < 1 {
+		t.Errorf("expected the seed to receive a ping, got %v", v)
+	}
+	if v := testutil.ToFloat64(seedCollector.packetsIn.WithLabelValues(dht.PacketRequest, "store")); v != 1 {
+		t.Errorf("expected the seed to receive 1 store, got %v", v)
+	}
+	if v := testutil.ToFloat64(nodeCollector.packetsOut.WithLabelValues(dht.PacketRequest, "store")); v != 1 {
+		t.Errorf("expected the node to send 1 store, got %v", v)
+	}
+	if v := testutil.ToFloat64(nodeCollector.announceQueue); v != 1 {
+		t.Errorf("expected announce queue length 1, got %v", v)
+	}
+
+	expected := `
+# HELP dht_routing_table_contacts Contacts in the routing table.
+# TYPE dht_routing_table_contacts gauge
+dht_routing_table_contacts 1
+`
+	err = testutil.CollectAndCompare(nodeCollector, strings.NewReader(expected), "dht_routing_table_contacts")
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected = `
+# HELP dht_stored_hashes Hashes other nodes have stored with this node.
+# TYPE dht_stored_hashes gauge
+dht_stored_hashes 1
+`
+	err = testutil.CollectAndCompare(seedCollector, strings.NewReader(expected), "dht_stored_hashes")
+	if err != nil {
+		t.Error(err)
+	}
+	lint(t, seedCollector)
+}
+
+func lint(t *testing.T, c prometheus.Collector) {
+	t.Helper()
+	problems, err := testutil.CollectAndLint(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("lint: %s: %s", p.Metric, p.Text)
+	}
+}
@@ -1,7 +1,9 @@
 package dht
 
 import (
+	"context"
 	"encoding/hex"
+	"math"
 	"net"
 	"strings"
 	"sync"
@@ -43,6 +45,8 @@ type Node struct {
 	conn UDPConn
 	// true if we've closed the connection on purpose
 	connClosed bool
+	// the address families the connection can send to
+	ipv4, ipv6 bool
 	// token manager
 	tokens *tokenManager
 
@@ -52,36 +56,77 @@ type Node struct {
 
 	// routing table
 	rt *routingTable
-	// data store
-	store *contactStore
+	// the peers other nodes announced to us
+	store PeerStore
 
 	// overrides for request handlers
 	requestHandler RequestHandlerFunc
 
+	// abuse protection. limiter is nil if requests are not rate limited
+	limiter   *requestLimiter
+	blocklist *blocklist
+	dropsMu   *sync.Mutex
+	drops     map[string]int
+
 	// stop the node neatly and clean up after itself
 	grp *stop.Group
+
+	// Kademlia parameters. only the node parameters of the config are used
+	conf Config
 }
 
 // NewNode returns an initialized Node's pointer.
 func NewNode(id bits.Bitmap) *Node {
+	return NewNodeWithConfig(id, NewStandardConfig())
+}
+
+// NewNodeWithConfig returns a node that uses the Kademlia parameters in conf. Parameters that are not set get their
+// default values.
+func NewNodeWithConfig(id bits.Bitmap, conf *Config) *Node {
+	c := *conf
+	c.setDefaults()
+
+	store := c.PeerStore
+	if store == nil {
+		store = NewMemoryPeerStore(c.peerStoreOptions())
+	}
+
+	blocked := newBlocklist()
+	for _, s := range c.Blocklist {
+		err := blocked.Add(s)
+		if err != nil {
+			log.Error(errors.Prefix("ignoring blocklist entry", err))
+		}
+	}
+
 	return &Node{
 		id:    id,
-		rt:    newRoutingTable(id),
-		store: newStore(),
+		conf:  c,
+		rt:    newRoutingTableWithConfig(id, c.BucketSize, c.MaxPeerFails),
+		store: store,
+
+		limiter:   newRequestLimiter(c.RequestRate, c.RequestBurst),
+		blocklist: blocked,
+		dropsMu:   &sync.Mutex{},
+		drops:     make(map[string]int),
 
 		txLock:       &sync.RWMutex{},
 		transactions: make(map[messageID]*transaction),
 
 		grp:    stop.New(),
 		tokens: &tokenManager{},
+
+		ipv4: true,
+		ipv6: true,
 	}
 }
 
 // Connect connects to the given connection and starts any background threads necessary
 func (n *Node) Connect(conn UDPConn) error {
 	n.conn = conn
+	n.ipv4, n.ipv6 = connFamilies(conn, n.conf.Address)
 
-	n.tokens.Start(tokenSecretRotationInterval)
+	n.tokens.Start(n.conf.TokenSecretRotationInterval)
 
 	go func() {
 		// stop tokens and close the connection when we're shutting down
@@ -149,13 +194,73 @@ func (n *Node) Connect(conn UDPConn) error {
 		n.startRoutingTableGrooming()
 	}()
 
+	n.grp.Add(1)
+	go func() {
+		defer n.grp.Done()
+		n.startStoreExpiration()
+	}()
+
+	if n.limiter != nil {
+		n.grp.Add(1)
+		go func() {
+			defer n.grp.Done()
+			n.startLimiterSweep()
+		}()
+	}
+
 	return nil
 }
 
+// connFamilies returns whether the connection can send to IPv4 and IPv6 addresses. It uses the local address of the
+// connection if it has one, and the configured address otherwise.
+func connFamilies(conn UDPConn, address string) (ipv4, ipv6 bool) {
+	var ip net.IP
+	if c, ok := conn.(interface{ LocalAddr() net.Addr }); ok {
+		if addr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+			ip = addr.IP
+		}
+	} else if host, _, err := net.SplitHostPort(address); err == nil {
+		ip = net.ParseIP(host)
+	}
+
+	switch {
+	case ip == nil || ip.Equal(net.IPv6unspecified):
+		return true, true // a socket bound to [::] is dual-stack
+	case ip.To4() != nil:
+		return true, false
+	default:
+		return false, true
+	}
+}
+
+// canReach returns true if the node's connection can send to the contact
+func (n *Node) canReach(c Contact) bool {
+	if c.IsIPv6() {
+		return n.ipv6
+	}
+	return n.ipv4
+}
+
+// network returns the network name for resolving addresses the node can send to
+func (n *Node) network() string {
+	switch {
+	case n.ipv4 && !n.ipv6:
+		return "udp4"
+	case n.ipv6 && !n.ipv4:
+		return "udp6"
+	default:
+		return "udp"
+	}
+}
+
 // Shutdown shuts down the node
 func (n *Node) Shutdown() {
 	log.Debugf("[%s] node shutting down", n.id.HexShort())
 	n.grp.StopAndWait()
+	err := n.store.Close()
+	if err != nil {
+		log.Error(errors.Prefix("closing peer store", err))
+	}
 	log.Debugf("[%s] node stopped", n.id.HexShort())
 }
 
@@ -168,12 +273,21 @@ func (n *Node) handlePacket(pkt packet) {
 		return
 	}
 
+	if n.blocklist.Contains(pkt.raddr.IP) {
+		n.dropped(DropBlocked)
+		return
+	}
+
 	// the following is a bit of a hack, but it lets us avoid decoding every message twice
 	// it depends on the data being a dict with 0 as the first key (so it starts with "d1:0i") and the message type as the first value
 	// TODO: test this more thoroughly
 
 	switch pkt.data[5] {
 	case '0' + requestType:
+		if !n.limiter.Allow(pkt.raddr.IP) {
+			n.dropped(DropRateLimited)
+			return
+		}
 		request := Request{}
 		err := bencode.DecodeBytes(pkt.data, &request)
 		if err != nil {
@@ -181,7 +295,10 @@ func (n *Node) handlePacket(pkt packet) {
 			return
 		}
 		log.Debugf("[%s] query %s: received request from %s: %s(%s)", n.id.HexShort(), request.ID.HexShort(), request.NodeID.HexShort(), request.Method, request.argsDebug())
+		n.conf.Metrics.PacketReceived(PacketRequest, request.Method)
+		start := time.Now()
 		n.handleRequest(pkt.raddr, request)
+		n.conf.Metrics.RequestHandled(request.Method, time.Since(start))
 
 	case '0' + responseType:
 		response := Response{}
@@ -191,6 +308,7 @@ func (n *Node) handlePacket(pkt packet) {
 			return
 		}
 		log.Debugf("[%s] query %s: received response from %s: %s", n.id.HexShort(), response.ID.HexShort(), response.NodeID.HexShort(), response.argsDebug())
+		n.conf.Metrics.PacketReceived(PacketResponse, "")
 		n.handleResponse(pkt.raddr, response)
 
 	case '0' + errorType:
@@ -201,6 +319,7 @@ func (n *Node) handlePacket(pkt packet) {
 			return
 		}
 		log.Debugf("[%s] query %s: received error from %s: %s", n.id.HexShort(), e.ID.HexShort(), e.NodeID.HexShort(), e.ExceptionType)
+		n.conf.Metrics.PacketReceived(PacketError, "")
 		n.handleError(pkt.raddr, e)
 
 	default:
@@ -233,17 +352,27 @@ func (n *Node) handleRequest(addr *net.UDPAddr, request Request) {
 			log.Error("error sending 'pingmethod' response message - ", err)
 		}
 	case storeMethod:
-		// TODO: we should be sending the IP in the request, not just using the sender's IP
 		// TODO: should we be using StoreArgs.NodeID or StoreArgs.Value.LbryID ???
 		if n.tokens.Verify(request.StoreArgs.Value.Token, request.NodeID, addr) {
-			n.Store(request.StoreArgs.BlobHash, Contact{ID: request.StoreArgs.NodeID, IP: addr.IP, Port: addr.Port, PeerPort: request.StoreArgs.Value.Port})
+			// the peer is stored at the address the request came from, never at an address in the request. the token
+			// proves the sender gets packets at this address, so nobody can announce a peer at someone else's IP
+			err := n.Store(request.StoreArgs.BlobHash, Contact{ID: request.StoreArgs.NodeID, IP: addr.IP, Port: addr.Port, PeerPort: request.StoreArgs.Value.Port})
+			if errors.Is(err, ErrStoreLimit) {
+				n.dropped(DropStoreLimit)
+				err = n.sendMessage(addr, Error{ID: request.ID, NodeID: n.id, ExceptionType: storeLimitError})
+				if err != nil {
+					log.Error("error sending 'storemethod' response message for store-limit - ", err)
+				}
+				break
+			}
 
-			err := n.sendMessage(addr, Response{ID: request.ID, NodeID: n.id, Data: storeSuccessResponse})
+			err = n.sendMessage(addr, Response{ID: request.ID, NodeID: n.id, Data: storeSuccessResponse})
 			if err != nil {
 				log.Error("error sending 'storemethod' response message - ", err)
 			}
 		} else {
-			err := n.sendMessage(addr, Error{ID: request.ID, NodeID: n.id, ExceptionType: "invalid-token"})
+			n.conf.Metrics.TokenVerificationFailed()
+			err := n.sendMessage(addr, Error{ID: request.ID, NodeID: n.id, ExceptionType: invalidTokenError})
 			if err != nil {
 				log.Error("error sending 'storemethod'response message for invalid-token - ", err)
 			}
@@ -256,7 +385,7 @@ func (n *Node) handleRequest(addr *net.UDPAddr, request Request) {
 		err := n.sendMessage(addr, Response{
 			ID:       request.ID,
 			NodeID:   n.id,
-			Contacts: n.rt.GetClosest(*request.Arg, bucketSize),
+			Contacts: contactsForVersion(n.rt.GetClosest(*request.Arg, n.conf.BucketSize), request.ProtocolVersion),
 		})
 		if err != nil {
 			log.Error("error sending 'findnodemethod' response message - ", err)
@@ -274,14 +403,29 @@ func (n *Node) handleRequest(addr *net.UDPAddr, request Request) {
 			Token:  n.tokens.Get(request.NodeID, addr),
 		}
 
-		if contacts := n.store.Get(*request.Arg); len(contacts) > 0 {
+		stored, err := n.store.Get(*request.Arg)
+		if err != nil {
+			log.Error(errors.Prefix("getting stored peers", err))
+		}
+		n.conf.Metrics.StoreLookup(len(stored) > 0)
+
+		peers := contactsForVersion(stored, request.ProtocolVersion)
+		if request.ProtocolVersion >= protocolVersionPaging {
+			// the requester can ask for more pages, so only send the page it asked for
+			res.ProtocolVersion = protocolVersion
+			peers, res.Page = n.peersPage(peers, request.Page)
+		} else if len(peers) > maxPeersPerResponse {
+			peers = peers[:maxPeersPerResponse]
+		}
+
+		if len(peers) > 0 || res.Page > 0 {
 			res.FindValueKey = request.Arg.RawString()
-			res.Contacts = contacts
+			res.Contacts = peers
 		} else {
-			res.Contacts = n.rt.GetClosest(*request.Arg, bucketSize)
+			res.Contacts = contactsForVersion(n.rt.GetClosest(*request.Arg, n.conf.BucketSize), request.ProtocolVersion)
 		}
 
-		err := n.sendMessage(addr, res)
+		err = n.sendMessage(addr, res)
 		if err != nil {
 			log.Error("error sending 'findvaluemethod' response message - ", err)
 		}
@@ -294,6 +438,36 @@ func (n *Node) handleRequest(addr *net.UDPAddr, request Request) {
 	n.rt.Fresh(Contact{ID: request.NodeID, IP: addr.IP, Port: addr.Port})
 }
 
+// peersPage returns the peers on a page of findValue results, and the number of pages. Pages have BucketSize peers,
+// like in the python daemon, unless that many would not fit in a response. Peers are sorted the same way for every
+// request, so the pages don't overlap.
+func (n *Node) peersPage(peers []Contact, page int) ([]Contact, uint8) {
+	if len(peers) == 0 {
+		return nil, 0
+	}
+
+	size := n.conf.BucketSize
+	if size > maxPeersPerResponse {
+		size = maxPeersPerResponse
+	}
+
+	sortByDistance(peers, n.id)
+	if maxPeers := math.MaxUint8 * size; len(peers) > maxPeers {
+		peers = peers[:maxPeers] // the page count has to fit in a byte
+	}
+
+	pages := (len(peers) + size - 1) / size
+	if page >= pages {
+		return nil, uint8(pages)
+	}
+	start := page * size
+	end := start + size
+	if end > len(peers) {
+		end = len(peers)
+	}
+	return peers[start:end], uint8(pages)
+}
+
 // handleResponse handles responses received from udp.
 func (n *Node) handleResponse(addr *net.UDPAddr, response Response) {
 	tx := n.txFind(response.ID, Contact{ID: response.NodeID, IP: addr.IP, Port: addr.Port})
@@ -310,7 +484,14 @@ func (n *Node) handleResponse(addr *net.UDPAddr, response Response) {
 
 // handleError handles errors received from udp.
 func (n *Node) handleError(addr *net.UDPAddr, e Error) {
-	spew.Dump(e)
+	tx := n.txFind(e.ID, Contact{ID: e.NodeID, IP: addr.IP, Port: addr.Port})
+	if tx != nil {
+		select {
+		case tx.errs <- e:
+		default:
+		}
+	}
+
 	n.rt.Fresh(Contact{ID: e.NodeID, IP: addr.IP, Port: addr.Port})
 }
 
@@ -321,10 +502,13 @@ func (n *Node) sendMessage(addr *net.UDPAddr, data Message) error {
 		return errors.Err(err)
 	}
 
+	kind, method := PacketError, ""
 	if req, ok := data.(Request); ok {
+		kind, method = PacketRequest, req.Method
 		log.Debugf("[%s] query %s: sending request to %s (%d bytes) %s(%s)",
 			n.id.HexShort(), req.ID.HexShort(), addr.String(), len(encoded), req.Method, req.argsDebug())
 	} else if res, ok := data.(Response); ok {
+		kind = PacketResponse
 		log.Debugf("[%s] query %s: sending response to %s (%d bytes) %s",
 			n.id.HexShort(), res.ID.HexShort(), addr.String(), len(encoded), res.argsDebug())
 	} else {
@@ -340,17 +524,37 @@ func (n *Node) sendMessage(addr *net.UDPAddr, data Message) error {
 	}
 
 	_, err = n.conn.WriteToUDP(encoded, addr)
-	return errors.Err(err)
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	n.conf.Metrics.PacketSent(kind, method)
+	return nil
 }
 
-// transaction represents a single query to the dht. it stores the queried contact, the request, and the response channel
+// transaction represents a single query to the dht. it stores the queried contact, the request, and the channels
+// that receive the response or error
 type transaction struct {
 	contact     Contact
 	req         Request
 	res         chan Response
+	errs        chan Error
 	skipIDCheck bool
 }
 
+// ErrRequestTimeout is returned when a contact does not answer a request
+var ErrRequestTimeout = errors.Base("request timed out")
+
+// RemoteError is returned when a contact answers a request with an error
+type RemoteError struct {
+	ExceptionType string
+	Response      []string
+}
+
+func (e *RemoteError) Error() string {
+	return "remote error: " + e.ExceptionType
+}
+
 // insert adds a transaction to the manager.
 func (n *Node) txInsert(tx *transaction) {
 	n.txLock.Lock()
@@ -380,12 +584,20 @@ func (n *Node) txFind(id messageID, c Contact) *transaction {
 
 // SendOptions controls the behavior of send calls
 type SendOptions struct {
-	skipIDCheck bool
+	// accept the response from whichever node answers at the contact's address. used when the ID is not known yet,
+	// like when pinging a seed node
+	SkipIDCheck bool
 }
 
 // SendAsync sends a transaction and returns a channel that will eventually contain the transaction response
 // The response channel is closed when the transaction is completed or times out.
 func (n *Node) SendAsync(contact Contact, req Request, options ...SendOptions) <-chan *Response {
+	return n.SendAsyncContext(context.Background(), contact, req, options...)
+}
+
+// SendAsyncContext is like SendAsync, but the transaction is abandoned when ctx is canceled. An abandoned transaction
+// does not count as a failure of the contact.
+func (n *Node) SendAsyncContext(ctx context.Context, contact Contact, req Request, options ...SendOptions) <-chan *Response {
 	ch := make(chan *Response, 1)
 
 	if contact.ID.Equals(n.id) {
@@ -396,46 +608,68 @@ func (n *Node) SendAsync(contact Contact, req Request, options ...SendOptions) <
 
 	go func() {
 		defer close(ch)
-
-		req.ID = newMessageID()
-		req.NodeID = n.id
-		tx := &transaction{
-			contact: contact,
-			req:     req,
-			res:     make(chan Response),
+		res, _ := n.sendRequest(ctx, contact, req, options...)
+		if res != nil {
+			ch <- res
 		}
+	}()
 
-		if len(options) > 0 && options[0].skipIDCheck {
-			tx.skipIDCheck = true
-		}
+	return ch
+}
 
-		n.txInsert(tx)
-		defer n.txDelete(tx.req.ID)
+// sendRequest sends a transaction and blocks until it is completed. It returns the response, a *RemoteError if the
+// contact answered with an error, or ErrRequestTimeout if the contact did not answer.
+func (n *Node) sendRequest(ctx context.Context, contact Contact, req Request, options ...SendOptions) (*Response, error) {
+	if contact.ID.Equals(n.id) {
+		return nil, errors.Err("sending query to self")
+	}
 
-		for i := 0; i < udpRetry; i++ {
-			err := n.sendMessage(contact.Addr(), tx.req)
-			if err != nil {
-				if !strings.Contains(err.Error(), "use of closed network connection") { // this only happens on localhost. real UDP has no connections
-					log.Error("send error: ", err)
-				}
-				continue
-			}
+	req.ID = newMessageID()
+	req.NodeID = n.id
+	req.ProtocolVersion = protocolVersion
+	tx := &transaction{
+		contact: contact,
+		req:     req,
+		res:     make(chan Response),
+		errs:    make(chan Error),
+	}
 
-			select {
-			case res := <-tx.res:
-				ch <- &res
-				return
-			case <-n.grp.Ch():
-				return
-			case <-time.After(udpTimeout):
+	if len(options) > 0 && options[0].SkipIDCheck {
+		tx.skipIDCheck = true
+	}
+
+	n.txInsert(tx)
+	defer n.txDelete(tx.req.ID)
+
+	for i := 0; i < n.conf.UDPRetry; i++ {
+		if ctx.Err() != nil {
+			return nil, errors.Err(ctx.Err())
+		}
+
+		err := n.sendMessage(contact.Addr(), tx.req)
+		if err != nil {
+			if !strings.Contains(err.Error(), "use of closed network connection") { // this only happens on localhost. real UDP has no connections
+				log.Error("send error: ", err)
 			}
+			continue
 		}
 
-		// notify routing table about a failure to respond
-		n.rt.Fail(tx.contact)
-	}()
+		select {
+		case res := <-tx.res:
+			return &res, nil
+		case e := <-tx.errs:
+			return nil, &RemoteError{ExceptionType: e.ExceptionType, Response: e.Response}
+		case <-n.grp.Ch():
+			return nil, errors.Err("node is shutting down")
+		case <-ctx.Done():
+			return nil, errors.Err(ctx.Err())
+		case <-time.After(n.conf.UDPTimeout):
+		}
+	}
 
-	return ch
+	// notify routing table about a failure to respond
+	n.rt.Fail(tx.contact)
+	return nil, ErrRequestTimeout
 }
 
 // Send sends a transaction and blocks until the response is available. It returns a response, or nil
@@ -444,6 +678,11 @@ func (n *Node) Send(contact Contact, req Request, options ...SendOptions) *Respo
 	return <-n.SendAsync(contact, req, options...)
 }
 
+// SendContext is like Send, but gives up and returns nil when ctx is canceled
+func (n *Node) SendContext(ctx context.Context, contact Contact, req Request, options ...SendOptions) *Response {
+	return <-n.SendAsyncContext(ctx, contact, req, options...)
+}
+
 // CountActiveTransactions returns the number of transactions in the manager
 func (n *Node) CountActiveTransactions() int {
 	n.txLock.Lock()
@@ -452,20 +691,53 @@ func (n *Node) CountActiveTransactions() int {
 }
 
 func (n *Node) startRoutingTableGrooming() {
-	refreshTicker := time.NewTicker(tRefresh / 5) // how often to check for buckets that need to be refreshed
+	refreshTicker := time.NewTicker(n.conf.RefreshInterval / 5) // how often to check for buckets that need to be refreshed
 	for {
 		select {
 		case <-refreshTicker.C:
-			RoutingTableRefresh(n, tRefresh, n.grp.Child())
+			RoutingTableRefresh(n, n.conf.RefreshInterval, n.grp.Child())
+		case <-n.grp.Ch():
+			return
+		}
+	}
+}
+
+func (n *Node) startStoreExpiration() {
+	sweepTicker := time.NewTicker(storeSweepInterval)
+	defer sweepTicker.Stop()
+	for {
+		select {
+		case <-sweepTicker.C:
+			removed, err := n.store.RemoveExpired()
+			if err != nil {
+				log.Error(errors.Prefix("removing expired peers", err))
+			}
+			if removed > 0 {
+				log.Debugf("[%s] expired %d stored peers", n.id.HexShort(), removed)
+			}
+		case <-n.grp.Ch():
+			return
+		}
+	}
+}
+
+func (n *Node) startLimiterSweep() {
+	sweepTicker := time.NewTicker(limiterSweepInterval)
+	defer sweepTicker.Stop()
+	for {
+		select {
+		case <-sweepTicker.C:
+			n.limiter.RemoveIdle()
 		case <-n.grp.Ch():
 			return
 		}
 	}
 }
 
-// Store stores a node contact in the node's contact store.
-func (n *Node) Store(hash bits.Bitmap, c Contact) {
-	n.store.Upsert(hash, c)
+// Store stores a node contact in the node's contact store. It returns ErrStoreLimit if the hash or the contact's IP
+// already has as many stored peers as the config allows.
+func (n *Node) Store(hash bits.Bitmap, c Contact) error {
+	return n.store.Upsert(hash, c, time.Now())
 }
 
 // AddKnownNode adds a known-good node to the routing table
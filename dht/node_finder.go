@@ -1,6 +1,7 @@
 package dht
 
 import (
+	"context"
 	"sync"
 	"time"
 
@@ -35,6 +36,15 @@ type contactFinder struct {
 
 	findValueMutex  *sync.Mutex
 	findValueResult []Contact
+	// if set, peers are passed to onPeer as they are found, and the search keeps going after the first value is found
+	onPeer func(Contact)
+	// peers that were found but not passed to onPeer yet. onPeer is called from deliverPeers without any locks held,
+	// so a slow onPeer does not hold up the probes
+	peerQueue []Contact
+	peerReady chan struct{}
+	// when streaming peers, the search stops once this many unique peers are found. 0 means search until exhaustion
+	wantPeers int
+	seenPeers map[string]bool
 
 	activeContactsMutex *sync.Mutex
 	activeContacts      []Contact
@@ -49,7 +59,47 @@ type contactFinder struct {
 }
 
 func FindContacts(node *Node, target bits.Bitmap, findValue bool, parentGrp *stop.Group) ([]Contact, bool, error) {
-	cf := &contactFinder{
+	return FindContactsContext(context.Background(), node, target, findValue, parentGrp)
+}
+
+// FindContactsContext is like FindContacts, but the search is abandoned and an error is returned when ctx is canceled.
+// Requests that are in flight when the search stops are abandoned too.
+func FindContactsContext(ctx context.Context, node *Node, target bits.Bitmap, findValue bool, parentGrp *stop.Group) ([]Contact, bool, error) {
+	cf := newContactFinder(node, target, findValue, parentGrp)
+	return cf.findContext(ctx)
+}
+
+// FindPeersStream searches for peers that have the target blob, and calls onPeer for each unique peer as soon as it's
+// found, instead of stopping at the first node that knows about the blob. The search stops when count peers have been
+// found (if count is more than 0), when no more nodes are left to ask, or when ctx is canceled. All the peers that
+// were found are returned. onPeer is called from a single goroutine, in the order peers are found, and never after
+// FindPeersStream returns. A slow onPeer does not slow down the search, but FindPeersStream waits for it to get
+// through every peer before returning.
+func FindPeersStream(ctx context.Context, node *Node, target bits.Bitmap, count int, parentGrp *stop.Group, onPeer func(Contact)) ([]Contact, error) {
+	cf := newContactFinder(node, target, true, parentGrp)
+	cf.onPeer = onPeer
+	cf.wantPeers = count
+	cf.seenPeers = make(map[string]bool)
+	cf.peerReady = make(chan struct{}, 1)
+
+	searchDone := make(chan struct{})
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		cf.deliverPeers(searchDone)
+	}()
+
+	peers, found, err := cf.findContext(ctx)
+	close(searchDone)
+	<-delivered // onPeer is never called after this returns
+	if err != nil || !found {
+		return nil, err
+	}
+	return peers, nil
+}
+
+func newContactFinder(node *Node, target bits.Bitmap, findValue bool, parentGrp *stop.Group) *contactFinder {
+	return &contactFinder{
 		node:                node,
 		target:              target,
 		findValue:           findValue,
@@ -61,8 +111,18 @@ func FindContacts(node *Node, target bits.Bitmap, findValue bool, parentGrp *sto
 		closestContactMutex: &sync.RWMutex{},
 		notGettingCloser:    atomic.NewBool(false),
 	}
+}
+
+// findContext runs Find, and stops it when ctx is canceled
+func (cf *contactFinder) findContext(ctx context.Context) ([]Contact, bool, error) {
+	stopWatching := context.AfterFunc(ctx, cf.grp.Stop)
+	defer stopWatching()
 
-	return cf.Find()
+	contacts, found, err := cf.Find()
+	if err == nil && ctx.Err() != nil {
+		return nil, false, errors.Err(ctx.Err())
+	}
+	return contacts, found, err
 }
 
 func (cf *contactFinder) Stop() {
@@ -76,7 +136,7 @@ func (cf *contactFinder) Find() ([]Contact, bool, error) {
 		cf.debug("starting iterativeFindNode")
 	}
 
-	cf.appendNewToShortlist(cf.node.rt.GetClosest(cf.target, alpha))
+	cf.appendNewToShortlist(cf.node.rt.GetClosest(cf.target, cf.node.conf.Alpha))
 	if len(cf.shortlist) == 0 {
 		return nil, false, errors.Err("[%s] find %s: no contacts in routing table", cf.node.id.HexShort(), cf.target.HexShort())
 	}
@@ -97,13 +157,16 @@ CycleLoop:
 
 	var contacts []Contact
 	var found bool
+	cf.findValueMutex.Lock()
 	if cf.findValue && len(cf.findValueResult) > 0 {
 		contacts = cf.findValueResult
 		found = true
-	} else {
+	}
+	cf.findValueMutex.Unlock()
+	if !found {
 		contacts = cf.activeContacts
-		if len(contacts) > bucketSize {
-			contacts = contacts[:bucketSize]
+		if len(contacts) > cf.node.conf.BucketSize {
+			contacts = contacts[:cf.node.conf.BucketSize]
 		}
 	}
 
@@ -128,9 +191,9 @@ func (cf *contactFinder) cycle(bigCycle bool) {
 	var wg sync.WaitGroup
 	ch := make(chan *Contact)
 
-	limit := alpha
+	limit := cf.node.conf.Alpha
 	if bigCycle {
-		limit = bucketSize
+		limit = cf.node.conf.BucketSize
 	}
 
 	for i := 0; i < limit; i++ {
@@ -210,7 +273,7 @@ func (cf *contactFinder) probe(cycleID string) *Contact {
 	}
 
 	var res *Response
-	resCh := cf.node.SendAsync(c, req)
+	resCh := cf.node.SendAsyncContext(cf.grp.Ctx(), c, req)
 	select {
 	case res = <-resCh:
 	case <-cf.grp.Ch():
@@ -223,10 +286,24 @@ func (cf *contactFinder) probe(cycleID string) *Contact {
 		return nil
 	}
 
+	if cf.findValue && res.FindValueKey != "" && cf.onPeer != nil {
+		cf.debug("|%s| probe %s: got value, continuing search", cycleID, c.ID.HexShort())
+		cf.insertIntoActiveList(c)
+		if cf.addPeers(res.Contacts) || cf.fetchPages(c, res.Page, cf.addPeers) {
+			cf.grp.Stop()
+		}
+		return nil
+	}
+
 	if cf.findValue && res.FindValueKey != "" {
 		cf.debug("|%s| probe %s: got value", cycleID, c.ID.HexShort())
+		peers := res.Contacts
+		cf.fetchPages(c, res.Page, func(page []Contact) bool {
+			peers = append(peers, page...)
+			return false
+		})
 		cf.findValueMutex.Lock()
-		cf.findValueResult = res.Contacts
+		cf.findValueResult = peers
 		cf.findValueMutex.Unlock()
 		cf.grp.Stop()
 		return nil
@@ -238,8 +315,8 @@ func (cf *contactFinder) probe(cycleID string) *Contact {
 
 	cf.activeContactsMutex.Lock()
 	contacts := cf.activeContacts
-	if len(contacts) > bucketSize {
-		contacts = contacts[:bucketSize]
+	if len(contacts) > cf.node.conf.BucketSize {
+		contacts = contacts[:cf.node.conf.BucketSize]
 	}
 	contactsStr := ""
 	for _, c := range contacts {
@@ -250,6 +327,86 @@ func (cf *contactFinder) probe(cycleID string) *Contact {
 	return cf.closest(res.Contacts...)
 }
 
+// fetchPages asks the contact for the rest of the pages of peers, after the first page was in the response to the
+// probe. Each page is passed to add. It stops when add returns true, when a page has no peers, or when the search is
+// stopped, and returns true if add returned true.
+func (cf *contactFinder) fetchPages(c Contact, pages uint8, add func([]Contact) bool) bool {
+	for page := 1; page < int(pages); page++ {
+		res := cf.node.SendContext(cf.grp.Ctx(), c, Request{Method: findValueMethod, Arg: &cf.target, Page: page})
+		if res == nil || res.FindValueKey == "" || len(res.Contacts) == 0 {
+			cf.debug("probe %s: no peers on page %d of %d", c.ID.HexShort(), page, pages)
+			return false
+		}
+		if add(res.Contacts) {
+			return true
+		}
+	}
+	return false
+}
+
+// addPeers passes peers that have not been seen before to onPeer. It returns true once enough peers have been found.
+func (cf *contactFinder) addPeers(peers []Contact) bool {
+	cf.findValueMutex.Lock()
+	defer cf.findValueMutex.Unlock()
+
+	select {
+	case <-cf.grp.Ch():
+		return false // the search is over. results were already returned
+	default:
+	}
+
+	for _, p := range peers {
+		if cf.wantPeers > 0 && len(cf.findValueResult) >= cf.wantPeers {
+			break
+		}
+		key := p.String()
+		if cf.seenPeers[key] {
+			continue
+		}
+		cf.seenPeers[key] = true
+		cf.findValueResult = append(cf.findValueResult, p)
+		cf.peerQueue = append(cf.peerQueue, p)
+	}
+
+	select {
+	case cf.peerReady <- struct{}{}:
+	default:
+	}
+
+	return cf.wantPeers > 0 && len(cf.findValueResult) >= cf.wantPeers
+}
+
+// deliverPeers passes queued peers to onPeer in the order they were found, until searchDone is closed and the queue
+// is empty
+func (cf *contactFinder) deliverPeers(searchDone <-chan struct{}) {
+	for {
+		cf.findValueMutex.Lock()
+		queued := cf.peerQueue
+		cf.peerQueue = nil
+		cf.findValueMutex.Unlock()
+
+		for _, p := range queued {
+			cf.onPeer(p)
+		}
+		if len(queued) > 0 {
+			continue
+		}
+
+		select {
+		case <-cf.peerReady:
+		case <-searchDone:
+			cf.findValueMutex.Lock()
+			queued = cf.peerQueue
+			cf.peerQueue = nil
+			cf.findValueMutex.Unlock()
+			for _, p := range queued {
+				cf.onPeer(p)
+			}
+			return
+		}
+	}
+}
+
 // appendNewToShortlist appends any new contacts to the shortlist and sorts it by distance
 // contacts that have already been added to the shortlist in the past are ignored
 func (cf *contactFinder) appendNewToShortlist(contacts []Contact) {
@@ -257,6 +414,9 @@ func (cf *contactFinder) appendNewToShortlist(contacts []Contact) {
 	defer cf.shortlistMutex.Unlock()
 
 	for _, c := range contacts {
+		if !cf.node.canReach(c) {
+			continue // e.g. an IPv6 contact when we only listen on IPv4
+		}
 		if _, ok := cf.shortlistAdded[c.ID]; !ok {
 			cf.shortlist = append(cf.shortlist, c)
 			cf.shortlistAdded[c.ID] = true
@@ -300,7 +460,14 @@ func (cf *contactFinder) insertIntoActiveList(contact Contact) {
 
 // isSearchFinished returns true if the search is done and should be stopped
 func (cf *contactFinder) isSearchFinished() bool {
-	if cf.findValue && len(cf.findValueResult) > 0 {
+	cf.findValueMutex.Lock()
+	foundValues := len(cf.findValueResult)
+	cf.findValueMutex.Unlock()
+
+	if cf.findValue && cf.onPeer == nil && foundValues > 0 {
+		return true
+	}
+	if cf.onPeer != nil && cf.wantPeers > 0 && foundValues >= cf.wantPeers {
 		return true
 	}
 
@@ -316,7 +483,7 @@ func (cf *contactFinder) isSearchFinished() bool {
 
 	cf.activeContactsMutex.Lock()
 	defer cf.activeContactsMutex.Unlock()
-	return len(cf.activeContacts) >= bucketSize
+	return len(cf.activeContacts) >= cf.node.conf.BucketSize
 }
 
 func (cf *contactFinder) debug(format string, args ...interface{}) {
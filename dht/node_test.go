@@ -1,11 +1,13 @@
 package dht
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
 
 	"github.com/lyoshenka/bencode"
 )
@@ -190,7 +192,7 @@ func TestStore(t *testing.T) {
 		t.Error("dht store has wrong number of items")
 	}
 
-	items := dht.node.store.Get(blobHashToStore)
+	items := mustGet(t, dht.node.store, blobHashToStore)
 	if len(items) != 1 {
 		t.Error("list created in store, but nothing in list")
 	}
@@ -291,9 +293,9 @@ func TestFindValueExisting(t *testing.T) {
 	valueToFind := bits.Rand()
 
 	nodeToFind := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), PeerPort: 1286}
-	dht.node.store.Upsert(valueToFind, nodeToFind)
-	dht.node.store.Upsert(valueToFind, nodeToFind)
-	dht.node.store.Upsert(valueToFind, nodeToFind)
+	dht.node.Store(valueToFind, nodeToFind)
+	dht.node.Store(valueToFind, nodeToFind)
+	dht.node.Store(valueToFind, nodeToFind)
 
 	request := Request{
 		ID:     messageID,
@@ -421,3 +423,309 @@ func TestFindValueFallbackToFindNode(t *testing.T) {
 
 	verifyContacts(t, contacts, nodes)
 }
+
+func TestNode_SendAsyncContext(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "127.0.0.1:21216", NodeID: bits.Rand().Hex()})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	contact := Contact{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), Port: 21218}
+	resCh := dht.node.SendAsyncContext(ctx, contact, Request{Method: pingMethod})
+
+	select {
+	case <-conn.writes: // the request was sent, but nobody will answer it
+	case <-time.After(1 * time.Second):
+		t.Fatal("request was not sent")
+	}
+
+	cancel()
+
+	select {
+	case res := <-resCh:
+		if res != nil {
+			t.Error("expected no response")
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("canceled request did not return before the udp timeout")
+	}
+
+	if dht.node.CountActiveTransactions() != 0 {
+		t.Error("canceled transaction was not removed")
+	}
+}
+
+func TestNewNodeWithConfig_Defaults(t *testing.T) {
+	n := NewNodeWithConfig(bits.Rand(), &Config{BucketSize: 4, UDPTimeout: 2 * time.Second})
+
+	if n.conf.BucketSize != 4 || n.conf.UDPTimeout != 2*time.Second {
+		t.Error("configured values were not used")
+	}
+	if n.conf.Alpha != defaultAlpha || n.conf.UDPRetry != defaultUDPRetry || n.conf.MaxPeerFails != defaultMaxPeerFails ||
+		n.conf.RefreshInterval != defaultRefreshInterval || n.conf.TokenSecretRotationInterval != defaultTokenSecretRotationInterval {
+		t.Error("values that were not configured should get defaults")
+	}
+	if n.rt.bucketSize != 4 {
+		t.Errorf("routing table should use bucket size 4, got %d", n.rt.bucketSize)
+	}
+}
+
+func TestNode_SendRequestError(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "127.0.0.1:21216", NodeID: bits.Rand().Hex(), UDPTimeout: 200 * time.Millisecond})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	contact := Contact{ID: bits.Rand(), IP: conn.addr.IP, Port: conn.addr.Port}
+
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := dht.node.sendRequest(context.Background(), contact, Request{Method: pingMethod})
+		errCh <- err
+	}()
+
+	var request map[string]interface{}
+	select {
+	case w := <-conn.writes:
+		err := bencode.DecodeBytes(w.data, &request)
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("request was not sent")
+	}
+
+	var id messageID
+	copy(id[:], request[headerMessageIDField].(string))
+	data, err := bencode.EncodeBytes(Error{ID: id, NodeID: contact.ID, ExceptionType: invalidTokenError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.toRead <- testUDPPacket{addr: conn.addr, data: data}
+
+	select {
+	case err := <-errCh:
+		remoteErr, ok := err.(*RemoteError)
+		if !ok {
+			t.Fatalf("expected a remote error, got %v", err)
+		}
+		if remoteErr.ExceptionType != invalidTokenError {
+			t.Errorf("expected exception type %s, got %s", invalidTokenError, remoteErr.ExceptionType)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("error was not routed to the transaction")
+	}
+
+	// a contact that does not answer times out
+	go func() {
+		_, err := dht.node.sendRequest(context.Background(), contact, Request{Method: pingMethod})
+		errCh <- err
+	}()
+	<-conn.writes
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, ErrRequestTimeout) {
+			t.Errorf("expected a timeout, got %v", err)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("request did not time out")
+	}
+}
+
+// sendTestRequest sends the request to the node over the test connection, and returns the decoded response
+func sendTestRequest(t *testing.T, conn *testUDPConn, req Request) Response {
+	t.Helper()
+
+	data, err := bencode.EncodeBytes(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.toRead <- testUDPPacket{addr: conn.addr, data: data}
+
+	select {
+	case w := <-conn.writes:
+		var res Response
+		err := bencode.DecodeBytes(w.data, &res)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	case <-time.After(3 * time.Second):
+		t.Fatal("timeout")
+	}
+	return Response{}
+}
+
+func TestFindNode_IPv6Interop(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "[::]:21216", NodeID: bits.Rand().Hex()})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	v4 := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444}
+	v6 := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::1"), Port: 4444}
+	dht.node.AddKnownNode(v4)
+	dht.node.AddKnownNode(v6)
+
+	target := bits.Rand()
+
+	// a node that does not send a protocol version, like an old python node, only gets IPv4 contacts
+	res := sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findNodeMethod, Arg: &target})
+	if len(res.Contacts) != 1 || !res.Contacts[0].Equals(v4, true) {
+		t.Errorf("expected only the IPv4 contact, got %v", res.Contacts)
+	}
+
+	res = sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findNodeMethod, Arg: &target, ProtocolVersion: protocolVersionIPv6})
+	if len(res.Contacts) != 2 {
+		t.Fatalf("expected both contacts, got %v", res.Contacts)
+	}
+	for _, c := range res.Contacts {
+		if !c.Equals(v4, true) && !c.Equals(v6, true) {
+			t.Errorf("unexpected contact %s", c.String())
+		}
+	}
+}
+
+func TestFindValue_IPv6Interop(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "[::]:21216", NodeID: bits.Rand().Hex()})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	hash := bits.Rand()
+	v4 := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), PeerPort: 3333}
+	v6 := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::1"), PeerPort: 3333}
+	dht.node.Store(hash, v4)
+	dht.node.Store(hash, v6)
+
+	res := sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findValueMethod, Arg: &hash})
+	if res.FindValueKey != hash.RawString() {
+		t.Fatal("expected the value to be found")
+	}
+	if len(res.Contacts) != 1 || !res.Contacts[0].ID.Equals(v4.ID) || !res.Contacts[0].IP.Equal(v4.IP) {
+		t.Errorf("expected only the IPv4 peer, got %v", res.Contacts)
+	}
+
+	res = sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findValueMethod, Arg: &hash, ProtocolVersion: protocolVersionIPv6})
+	if len(res.Contacts) != 2 {
+		t.Fatalf("expected both peers, got %v", res.Contacts)
+	}
+	for _, c := range res.Contacts {
+		if c.ID.Equals(v6.ID) && (!c.IP.Equal(v6.IP) || c.PeerPort != v6.PeerPort) {
+			t.Errorf("IPv6 peer was not decoded correctly: %s", c.String())
+		}
+	}
+}
+
+func TestNode_IPv4OnlyLookup(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "127.0.0.1:21216", NodeID: bits.Rand().Hex()})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	if !dht.node.ipv4 || dht.node.ipv6 {
+		t.Fatal("node listening on an IPv4 address should only reach IPv4 contacts")
+	}
+
+	v4 := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444}
+	v6 := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::1"), Port: 4444}
+
+	cf := newContactFinder(dht.node, bits.Rand(), false, nil)
+	cf.appendNewToShortlist([]Contact{v4, v6})
+	if len(cf.shortlist) != 1 || !cf.shortlist[0].Equals(v4, true) {
+		t.Errorf("expected only the IPv4 contact in the shortlist, got %v", cf.shortlist)
+	}
+}
+
+func TestConnFamilies(t *testing.T) {
+	tests := []struct {
+		address    string
+		ipv4, ipv6 bool
+	}{
+		{"0.0.0.0:4444", true, false},
+		{"127.0.0.1:4444", true, false},
+		{"[::]:4444", true, true},
+		{"[::1]:4444", false, true},
+		{":4444", true, true},
+	}
+
+	for _, test := range tests {
+		ipv4, ipv6 := connFamilies(newTestUDPConn("127.0.0.1:21217"), test.address)
+		if ipv4 != test.ipv4 || ipv6 != test.ipv6 {
+			t.Errorf("%s: expected ipv4=%t ipv6=%t, got ipv4=%t ipv6=%t", test.address, test.ipv4, test.ipv6, ipv4, ipv6)
+		}
+	}
+}
+
+func TestFindValue_Pages(t *testing.T) {
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{Address: "127.0.0.1:21216", NodeID: bits.Rand().Hex()})
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+
+	hash := bits.Rand()
+	stored := make(map[bits.Bitmap]bool)
+	for i := 0; i < 20; i++ {
+		c := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444, PeerPort: 3333 + i}
+		err := dht.node.Store(hash, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		stored[c.ID] = true
+	}
+
+	seen := make(map[bits.Bitmap]bool)
+	for page := 0; page < 4; page++ {
+		res := sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findValueMethod, Arg: &hash, ProtocolVersion: protocolVersionPaging, Page: page})
+		if res.FindValueKey != hash.RawString() {
+			t.Fatalf("page %d: expected the hash to be found", page)
+		}
+		if res.Page != 3 {
+			t.Errorf("page %d: expected 3 pages, got %d", page, res.Page)
+		}
+		if page == 3 && len(res.Contacts) != 0 {
+			t.Errorf("expected no peers past the last page, got %d", len(res.Contacts))
+		}
+		for _, c := range res.Contacts {
+			if seen[c.ID] {
+				t.Errorf("page %d: %s was already on an earlier page", page, c.ID.HexShort())
+			}
+			seen[c.ID] = true
+		}
+	}
+	if len(seen) != len(stored) {
+		t.Errorf("expected the pages to have all %d peers, got %d", len(stored), len(seen))
+	}
+
+	// a node that does not know about pages gets as many peers as fit in one packet
+	res := sendTestRequest(t, conn, Request{ID: newMessageID(), NodeID: bits.Rand(), Method: findValueMethod, Arg: &hash})
+	if len(res.Contacts) != len(stored) || res.Page != 0 {
+		t.Errorf("expected all %d peers on one page, got %d peers and %d pages", len(stored), len(res.Contacts), res.Page)
+	}
+}
@@ -61,24 +61,29 @@ type bucket struct {
 	peers      []peer
 	lastUpdate time.Time
 	Range      bits.Range // capitalized because `range` is a keyword
+
+	size         int // max number of peers in the bucket
+	maxPeerFails int // peers that failed this many times can be replaced when the bucket is full
 }
 
-func newBucket(r bits.Range) *bucket {
+func newBucket(r bits.Range, size, maxPeerFails int) *bucket {
 	return &bucket{
-		peers: make([]peer, 0, bucketSize),
-		lock:  &sync.RWMutex{},
-		Range: r,
+		peers:        make([]peer, 0, size),
+		lock:         &sync.RWMutex{},
+		Range:        r,
+		size:         size,
+		maxPeerFails: maxPeerFails,
 	}
 }
 
 // Len returns the number of peers in the bucket
-func (b bucket) Len() int {
+func (b *bucket) Len() int {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 	return len(b.peers)
 }
 
-func (b bucket) Has(c Contact) bool {
+func (b *bucket) Has(c Contact) bool {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 	for _, p := range b.peers {
@@ -90,7 +95,7 @@ func (b bucket) Has(c Contact) bool {
 }
 
 // Contacts returns a slice of the bucket's contacts
-func (b bucket) Contacts() []Contact {
+func (b *bucket) Contacts() []Contact {
 	b.lock.RLock()
 	defer b.lock.RUnlock()
 	contacts := make([]Contact, len(b.peers))
@@ -117,10 +122,10 @@ func (b *bucket) UpdatePeer(p peer, insertIfNew bool) error {
 	} else if insertIfNew {
 		hasRoom := true
 
-		if len(b.peers) >= bucketSize {
+		if len(b.peers) >= b.size {
 			hasRoom = false
 			for i := range b.peers {
-				if b.peers[i].IsBad(maxPeerFails) {
+				if b.peers[i].IsBad(b.maxPeerFails) {
 					// TODO: Ping contact first. Only remove if it does not respond
 					b.peers = append(b.peers[:i], b.peers[i+1:]...)
 					hasRoom = true
@@ -171,8 +176,8 @@ func (b *bucket) Split() (*bucket, *bucket) {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	left := newBucket(b.Range.IntervalP(1, 2))
-	right := newBucket(b.Range.IntervalP(2, 2))
+	left := newBucket(b.Range.IntervalP(1, 2), b.size, b.maxPeerFails)
+	right := newBucket(b.Range.IntervalP(2, 2), b.size, b.maxPeerFails)
 	left.lastUpdate = b.lastUpdate
 	right.lastUpdate = b.lastUpdate
 
@@ -201,12 +206,21 @@ type routingTable struct {
 	id      bits.Bitmap
 	buckets []*bucket
 	mu      *sync.RWMutex // this mutex is write-locked only when CHANGING THE NUMBER OF BUCKETS in the table
+
+	bucketSize   int
+	maxPeerFails int
 }
 
 func newRoutingTable(id bits.Bitmap) *routingTable {
+	return newRoutingTableWithConfig(id, defaultBucketSize, defaultMaxPeerFails)
+}
+
+func newRoutingTableWithConfig(id bits.Bitmap, bucketSize, maxPeerFails int) *routingTable {
 	rt := routingTable{
-		id: id,
-		mu: &sync.RWMutex{},
+		id:           id,
+		mu:           &sync.RWMutex{},
+		bucketSize:   bucketSize,
+		maxPeerFails: maxPeerFails,
 	}
 	rt.reset()
 	return &rt
@@ -215,7 +229,7 @@ func newRoutingTable(id bits.Bitmap) *routingTable {
 func (rt *routingTable) reset() {
 	rt.mu.Lock()
 	defer rt.mu.Unlock()
-	rt.buckets = []*bucket{newBucket(bits.MaxRange())}
+	rt.buckets = []*bucket{newBucket(bits.MaxRange(), rt.bucketSize, rt.maxPeerFails)}
 }
 
 func (rt *routingTable) BucketInfo() string {
@@ -320,6 +334,17 @@ func (rt *routingTable) Count() int {
 	return count
 }
 
+// BucketCounts returns the number of contacts in each bucket, in bucket order
+func (rt *routingTable) BucketCounts() []int {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+	counts := make([]int, len(rt.buckets))
+	for i, bucket := range rt.buckets {
+		counts[i] = bucket.Len()
+	}
+	return counts
+}
+
 // Len returns the number of buckets in the routing table
 func (rt *routingTable) Len() int {
 	rt.mu.RLock()
@@ -344,11 +369,11 @@ func (rt *routingTable) shouldSplit(b *bucket, c Contact) bool {
 	if b.Has(c) {
 		return false
 	}
-	if b.Len() >= bucketSize {
+	if b.Len() >= rt.bucketSize {
 		if b.Range.Start.Equals(bits.Bitmap{}) { // this is the bucket covering our node id
 			return true
 		}
-		kClosest := rt.getClosest(rt.id, bucketSize)
+		kClosest := rt.getClosest(rt.id, rt.bucketSize)
 		kthClosest := kClosest[len(kClosest)-1]
 		if rt.id.Closer(c.ID, kthClosest.ID) {
 			return true
@@ -377,6 +402,20 @@ func (rt *routingTable) GetIDsForRefresh(refreshInterval time.Duration) []bits.B
 	return bitmaps
 }
 
+// GetIDsFartherThan returns a random ID in each bucket whose contacts are all farther from this node than distance
+func (rt *routingTable) GetIDsFartherThan(distance bits.Bitmap) []bits.Bitmap {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	var ids []bits.Bitmap
+	for _, b := range rt.buckets {
+		if b.Range.Start.Cmp(distance) > 0 {
+			ids = append(ids, rt.id.Xor(b.Range.Rand()))
+		}
+	}
+	return ids
+}
+
 const rtContactSep = "-"
 
 type rtSave struct {
@@ -385,11 +424,14 @@ type rtSave struct {
 }
 
 func (rt *routingTable) MarshalJSON() ([]byte, error) {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
 	var data rtSave
 	data.ID = rt.id.Hex()
 	for _, b := range rt.buckets {
 		for _, c := range b.Contacts() {
-			data.Contacts = append(data.Contacts, strings.Join([]string{c.ID.Hex(), c.IP.String(), strconv.Itoa(c.Port)}, rtContactSep))
+			data.add(c)
 		}
 	}
 	return json.Marshal(data)
@@ -408,35 +450,59 @@ func (rt *routingTable) UnmarshalJSON(b []byte) error {
 	}
 	rt.reset()
 
+	contacts, err := data.contacts()
+	if err != nil {
+		return err
+	}
+	for _, c := range contacts {
+		rt.Update(c)
+	}
+
+	return nil
+}
+
+// add encodes the contact and adds it to the saved contacts
+func (data *rtSave) add(c Contact) {
+	data.Contacts = append(data.Contacts, strings.Join([]string{c.ID.Hex(), c.IP.String(), strconv.Itoa(c.Port)}, rtContactSep))
+}
+
+// contacts decodes the saved contacts
+func (data rtSave) contacts() ([]Contact, error) {
+	contacts := make([]Contact, 0, len(data.Contacts))
 	for _, s := range data.Contacts {
 		parts := strings.Split(s, rtContactSep)
 		if len(parts) != 3 {
-			return errors.Err("decoding contact %s: wrong number of parts", s)
+			return nil, errors.Err("decoding contact %s: wrong number of parts", s)
 		}
 		var c Contact
+		var err error
 		c.ID, err = bits.FromHex(parts[0])
 		if err != nil {
-			return errors.Err("decoding contact %s: invalid ID: %s", s, err)
+			return nil, errors.Err("decoding contact %s: invalid ID: %s", s, err)
 		}
 		c.IP = net.ParseIP(parts[1])
 		if c.IP == nil {
-			return errors.Err("decoding contact %s: invalid IP", s)
+			return nil, errors.Err("decoding contact %s: invalid IP", s)
 		}
 		c.Port, err = strconv.Atoi(parts[2])
 		if err != nil {
-			return errors.Err("decoding contact %s: invalid port: %s", s, err)
+			return nil, errors.Err("decoding contact %s: invalid port: %s", s, err)
 		}
-		rt.Update(c)
+		contacts = append(contacts, c)
 	}
-
-	return nil
+	return contacts, nil
 }
 
 // RoutingTableRefresh refreshes any buckets that need to be refreshed
 func RoutingTableRefresh(n *Node, refreshInterval time.Duration, parentGrp *stop.Group) {
+	refreshIDs(n, n.rt.GetIDsForRefresh(refreshInterval), parentGrp)
+}
+
+// refreshIDs looks up each of the ids, which adds the contacts found along the way to the routing table
+func refreshIDs(n *Node, ids []bits.Bitmap, parentGrp *stop.Group) {
 	done := stop.New()
 
-	for _, id := range n.rt.GetIDsForRefresh(refreshInterval) {
+	for _, id := range ids {
 		done.Add(1)
 		go func(id bits.Bitmap) {
 			defer done.Done()
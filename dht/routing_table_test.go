@@ -105,7 +105,7 @@ func bucketNumFor(rt *routingTable, target bits.Bitmap) int {
 }
 
 func TestBucket_Split_Continuous(t *testing.T) {
-	b := newBucket(bits.MaxRange())
+	b := newBucket(bits.MaxRange(), defaultBucketSize, defaultMaxPeerFails)
 
 	left, right := b.Split()
 
@@ -288,13 +288,13 @@ func TestRoutingTable_Save(t *testing.T) {
 	rt := newRoutingTable(id)
 
 	for i, b := range rt.buckets {
-		for j := 0; j < bucketSize; j++ {
+		for j := 0; j < defaultBucketSize; j++ {
 			toAdd := b.Range.Start.Add(bits.FromShortHexP(strconv.Itoa(j)))
 			if toAdd.Cmp(b.Range.End) <= 0 {
 				rt.Update(Contact{
 					ID:   b.Range.Start.Add(bits.FromShortHexP(strconv.Itoa(j))),
 					IP:   net.ParseIP("1.2.3." + strconv.Itoa(j)),
-					Port: 1 + i*bucketSize + j,
+					Port: 1 + i*defaultBucketSize + j,
 				})
 			}
 		}
@@ -326,3 +326,45 @@ func TestRoutingTable_Load_ID(t *testing.T) {
 func TestRoutingTable_Load_Contacts(t *testing.T) {
 	t.Skip("TODO")
 }
+
+func TestRoutingTable_BucketSize(t *testing.T) {
+	id := bits.FromHexP("000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000")
+	rt := newRoutingTableWithConfig(id, 2, defaultMaxPeerFails)
+
+	// each contact is further from our id than the ones before it, so the far bucket fills up without splitting
+	for i := 5; i >= 1; i-- {
+		rt.Update(Contact{ID: bits.MaxP().Sub(bits.FromShortHexP(strconv.Itoa(i))), IP: net.ParseIP("127.0.0.1"), Port: i})
+	}
+
+	b := rt.bucketFor(bits.MaxP())
+	if b.Len() != 2 {
+		t.Errorf("expected bucket with 2 contacts, got %d", b.Len())
+	}
+}
+
+func TestRoutingTable_GetIDsFartherThan(t *testing.T) {
+	id := bits.Rand()
+	rt := newRoutingTable(id)
+	for i := 0; i < 100; i++ {
+		rt.Update(Contact{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), Port: 8000 + i})
+	}
+	if len(rt.buckets) < 2 {
+		t.Fatalf("expected the routing table to split, got %d buckets", len(rt.buckets))
+	}
+
+	closest := rt.GetClosest(id, 1)[0].ID.Xor(id)
+	ids := rt.GetIDsFartherThan(closest)
+	if len(ids) == 0 || len(ids) >= len(rt.buckets) {
+		t.Fatalf("expected IDs for some but not all of the %d buckets, got %d", len(rt.buckets), len(ids))
+	}
+
+	for _, refreshID := range ids {
+		distance := refreshID.Xor(id)
+		if distance.Cmp(closest) <= 0 {
+			t.Errorf("ID %s is not farther than the closest contact", refreshID.HexShort())
+		}
+		if !rt.bucketFor(refreshID).Range.Contains(distance) {
+			t.Errorf("ID %s is not in its bucket", refreshID.HexShort())
+		}
+	}
+}
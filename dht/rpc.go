@@ -2,10 +2,13 @@ package dht
 
 import (
 	"context"
+	"crypto/subtle"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
 	"github.com/lbryio/lbry.go/v2/extras/errors"
@@ -153,11 +156,172 @@ func (rpc *rpcReceiver) GetRoutingTable(r *http.Request, args *struct{}, result
 	return nil
 }
 
+type RpcAnnounceStatusResponse struct {
+	Hashes         int
+	NeverAnnounced int
+	Lagging        int
+	Capacity       int
+	Interval       string
+	NextAnnounce   string
+}
+
+func (rpc *rpcReceiver) GetAnnounceStatus(r *http.Request, args *struct{}, result *RpcAnnounceStatusResponse) error {
+	status := rpc.dht.AnnounceStatus()
+	*result = RpcAnnounceStatusResponse{
+		Hashes:         status.Hashes,
+		NeverAnnounced: status.NeverAnnounced,
+		Lagging:        status.Lagging,
+		Capacity:       status.Capacity,
+		Interval:       status.Interval.String(),
+	}
+	if !status.NextAnnounce.IsZero() {
+		result.NextAnnounce = status.NextAnnounce.Format(time.RFC3339)
+	}
+	return nil
+}
+
 func (rpc *rpcReceiver) AddKnownNode(r *http.Request, args *Contact, result *string) error {
 	rpc.dht.node.AddKnownNode(*args)
 	return nil
 }
 
+type RpcHashArgs struct {
+	Hash string
+}
+
+type RpcAnnouncedHash struct {
+	Hash         string
+	LastAnnounce string
+}
+
+func (rpc *rpcReceiver) GetAnnouncedHashes(r *http.Request, args *struct{}, result *[]RpcAnnouncedHash) error {
+	times := rpc.dht.AnnounceTimes()
+	hashes := make([]RpcAnnouncedHash, 0, len(times))
+	for h, t := range times {
+		a := RpcAnnouncedHash{Hash: h.Hex()}
+		if !t.IsZero() {
+			a.LastAnnounce = t.Format(time.RFC3339)
+		}
+		hashes = append(hashes, a)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i].Hash < hashes[j].Hash })
+	*result = hashes
+	return nil
+}
+
+func (rpc *rpcReceiver) AddAnnouncedHash(r *http.Request, args *RpcHashArgs, result *string) error {
+	hash, err := bits.FromHex(args.Hash)
+	if err != nil {
+		return err
+	}
+	rpc.dht.Add(hash)
+	*result = "added"
+	return nil
+}
+
+func (rpc *rpcReceiver) RemoveAnnouncedHash(r *http.Request, args *RpcHashArgs, result *string) error {
+	hash, err := bits.FromHex(args.Hash)
+	if err != nil {
+		return err
+	}
+	rpc.dht.Remove(hash)
+	*result = "removed"
+	return nil
+}
+
+func (rpc *rpcReceiver) GetStoredPeers(r *http.Request, args *RpcHashArgs, result *[]Contact) error {
+	hash, err := bits.FromHex(args.Hash)
+	if err != nil {
+		return err
+	}
+	peers, err := rpc.dht.node.store.Get(hash)
+	if err != nil {
+		return err
+	}
+	*result = append([]Contact{}, peers...)
+	return nil
+}
+
+func (rpc *rpcReceiver) RefreshBuckets(r *http.Request, args *struct{}, result *int) error {
+	*result = rpc.dht.RefreshBuckets()
+	return nil
+}
+
+type RpcJoinStatusResponse struct {
+	State          string
+	Since          string
+	Contacts       int
+	Rejoins        int
+	FailedAttempts int
+	NextAttempt    string
+}
+
+func newRpcJoinStatusResponse(s JoinStatus) RpcJoinStatusResponse {
+	res := RpcJoinStatusResponse{
+		State:          string(s.State),
+		Since:          s.Since.Format(time.RFC3339),
+		Contacts:       s.Contacts,
+		Rejoins:        s.Rejoins,
+		FailedAttempts: s.FailedAttempts,
+	}
+	if !s.NextAttempt.IsZero() {
+		res.NextAttempt = s.NextAttempt.Format(time.RFC3339)
+	}
+	return res
+}
+
+func (rpc *rpcReceiver) Rejoin(r *http.Request, args *struct{}, result *RpcJoinStatusResponse) error {
+	if !rpc.dht.Rejoin() {
+		return errors.Err("no nodes responded")
+	}
+	*result = newRpcJoinStatusResponse(rpc.dht.JoinStatus())
+	return nil
+}
+
+type RpcTokenStatusResponse struct {
+	Interval     string
+	LastRotation string
+	NextRotation string
+	Rotations    int
+}
+
+func (rpc *rpcReceiver) GetTokenStatus(r *http.Request, args *struct{}, result *RpcTokenStatusResponse) error {
+	status := rpc.dht.TokenStatus()
+	*result = RpcTokenStatusResponse{
+		Interval:     status.Interval.String(),
+		LastRotation: status.LastRotation.Format(time.RFC3339),
+		NextRotation: status.NextRotation.Format(time.RFC3339),
+		Rotations:    status.Rotations,
+	}
+	return nil
+}
+
+type RpcStatsResponse struct {
+	NodeID   string
+	Stats    Stats
+	Join     RpcJoinStatusResponse
+	Announce RpcAnnounceStatusResponse
+}
+
+func (rpc *rpcReceiver) GetStats(r *http.Request, args *struct{}, result *RpcStatsResponse) error {
+	result.NodeID = rpc.dht.node.id.Hex()
+	result.Stats = rpc.dht.Stats()
+	result.Join = newRpcJoinStatusResponse(rpc.dht.JoinStatus())
+	return rpc.GetAnnounceStatus(r, args, &result.Announce)
+}
+
+// rpcAuth rejects requests that don't have the auth token
+func rpcAuth(token string, next http.Handler) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (dht *DHT) runRPCServer(port int) {
 	addr := "0.0.0.0:" + strconv.Itoa(port)
 
@@ -170,8 +334,13 @@ func (dht *DHT) runRPCServer(port int) {
 		return
 	}
 
+	var h http.Handler = s
+	if dht.conf.RPCAuthToken != "" {
+		h = rpcAuth(dht.conf.RPCAuthToken, s)
+	}
+
 	handler := mux.NewRouter()
-	handler.Handle("/", s)
+	handler.Handle("/", h)
 	server := &http.Server{Addr: addr, Handler: handler}
 
 	wg := sync.WaitGroup{}
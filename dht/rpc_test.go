@@ -0,0 +1,170 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+const testRPCURL = "http://127.0.0.1:21315/"
+
+// callRPC calls the method with params and decodes the result into result. It returns the HTTP status and the error
+// returned by the method, if any.
+func callRPC(t *testing.T, token, method string, params interface{}, result interface{}) (int, string) {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{"method": "rpc." + method, "params": []interface{}{params}, "id": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, testRPCURL, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusUnauthorized {
+		return res.StatusCode, ""
+	}
+
+	var decoded struct {
+		Result json.RawMessage
+		Error  interface{}
+	}
+	err = json.NewDecoder(res.Body).Decode(&decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Error != nil {
+		return res.StatusCode, decoded.Error.(string)
+	}
+	if result != nil {
+		err = json.Unmarshal(decoded.Result, result)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return res.StatusCode, ""
+}
+
+// waitForRPC waits until the RPC server accepts connections
+func waitForRPC(t *testing.T) {
+	t.Helper()
+	for i := 0; i < 50; i++ {
+		conn, err := net.Dial("tcp", "127.0.0.1:21315")
+		if err == nil {
+			conn.Close()
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("rpc server did not start")
+}
+
+func TestRPC(t *testing.T) {
+	token := "secret"
+	dht := New(&Config{
+		Address:      "127.0.0.1:21314",
+		NodeID:       bits.Rand().Hex(),
+		RPCPort:      21315,
+		RPCAuthToken: token,
+	})
+	err := dht.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.Shutdown()
+	waitForRPC(t)
+
+	for _, wrong := range []string{"", "wrong"} {
+		if status, _ := callRPC(t, wrong, "GetStats", struct{}{}, nil); status != http.StatusUnauthorized {
+			t.Errorf("expected a request with token %q to be rejected, got status %d", wrong, status)
+		}
+	}
+
+	// announced hashes
+	hash := bits.Rand()
+	if _, rpcErr := callRPC(t, token, "AddAnnouncedHash", RpcHashArgs{Hash: hash.Hex()}, nil); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	var announced []RpcAnnouncedHash
+	for i := 0; i < 50 && len(announced) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		callRPC(t, token, "GetAnnouncedHashes", struct{}{}, &announced)
+	}
+	if len(announced) != 1 || announced[0].Hash != hash.Hex() {
+		t.Fatalf("expected the added hash to be announced, got %v", announced)
+	}
+	if _, rpcErr := callRPC(t, token, "RemoveAnnouncedHash", RpcHashArgs{Hash: hash.Hex()}, nil); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	for i := 0; i < 50 && len(announced) != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		callRPC(t, token, "GetAnnouncedHashes", struct{}{}, &announced)
+	}
+	if len(announced) != 0 {
+		t.Errorf("expected no announced hashes after removing the hash, got %v", announced)
+	}
+	if _, rpcErr := callRPC(t, token, "AddAnnouncedHash", RpcHashArgs{Hash: "nope"}, nil); rpcErr == "" {
+		t.Error("expected an invalid hash to be rejected")
+	}
+
+	// stored peers
+	peer := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444, PeerPort: 3333}
+	err = dht.node.Store(hash, peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []struct {
+		ID       string
+		PeerPort int
+	}
+	if _, rpcErr := callRPC(t, token, "GetStoredPeers", RpcHashArgs{Hash: hash.Hex()}, &stored); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	if len(stored) != 1 || stored[0].ID != peer.ID.Hex() || stored[0].PeerPort != peer.PeerPort {
+		t.Errorf("expected the stored peer, got %v", stored)
+	}
+
+	var tokens RpcTokenStatusResponse
+	if _, rpcErr := callRPC(t, token, "GetTokenStatus", struct{}{}, &tokens); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	if tokens.Rotations != 1 || tokens.Interval != defaultTokenSecretRotationInterval.String() || tokens.LastRotation == "" {
+		t.Errorf("unexpected token status %+v", tokens)
+	}
+
+	var stats RpcStatsResponse
+	if _, rpcErr := callRPC(t, token, "GetStats", struct{}{}, &stats); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	if stats.NodeID != dht.node.id.Hex() || stats.Stats.StoredHashes != 1 || stats.Join.State != string(JoinStateIsolated) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	var refreshed int
+	if _, rpcErr := callRPC(t, token, "RefreshBuckets", struct{}{}, &refreshed); rpcErr != "" {
+		t.Fatal(rpcErr)
+	}
+	if refreshed != 1 {
+		t.Errorf("expected the only bucket to be refreshed, got %d", refreshed)
+	}
+
+	// there are no seed nodes, so nobody can respond
+	if _, rpcErr := callRPC(t, token, "Rejoin", struct{}{}, nil); rpcErr == "" {
+		t.Error("expected rejoining to fail without seed nodes")
+	}
+}
@@ -0,0 +1,108 @@
+package dht
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// stateSave is the on-disk format of the state file
+type stateSave struct {
+	RoutingTable json.RawMessage `json:"routing_table"`
+	Hashes       []string        `json:"hashes"`
+}
+
+// savedState is the decoded contents of a state file
+type savedState struct {
+	nodeID   string
+	contacts []Contact
+	hashes   []bits.Bitmap
+}
+
+// loadState reads a state file saved by saveState. It returns nil if the file does not exist.
+func loadState(path string) (*savedState, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Err(err)
+	}
+
+	var data stateSave
+	err = json.Unmarshal(b, &data)
+	if err != nil {
+		return nil, errors.Prefix("decoding state file", err)
+	}
+
+	var rt rtSave
+	err = json.Unmarshal(data.RoutingTable, &rt)
+	if err != nil {
+		return nil, errors.Prefix("decoding routing table", err)
+	}
+
+	state := &savedState{nodeID: rt.ID}
+
+	state.contacts, err = rt.contacts()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, h := range data.Hashes {
+		hash, err := bits.FromHex(h)
+		if err != nil {
+			return nil, errors.Prefix("decoding hash "+h, err)
+		}
+		state.hashes = append(state.hashes, hash)
+	}
+
+	return state, nil
+}
+
+// saveState writes the routing table and the hashes being announced to the state file
+func (dht *DHT) saveState() error {
+	rt, err := dht.node.rt.MarshalJSON()
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	data := stateSave{RoutingTable: rt, Hashes: []string{}}
+	for _, h := range dht.announcedHashes() {
+		data.Hashes = append(data.Hashes, h.Hex())
+	}
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	return writeFileAtomic(dht.conf.StateFile, b)
+}
+
+// writeFileAtomic writes to a temp file and renames it, so a crash while saving doesn't leave a corrupted file
+func writeFileAtomic(path string, b []byte) error {
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, b, 0600)
+	if err != nil {
+		return errors.Err(err)
+	}
+	return errors.Err(os.Rename(tmpPath, path))
+}
+
+func (dht *DHT) runStateSaver() {
+	t := time.NewTicker(stateSaveInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			err := dht.saveState()
+			if err != nil {
+				log.Error(errors.Prefix("saving dht state", err))
+			}
+		case <-dht.grp.Ch():
+			return
+		}
+	}
+}
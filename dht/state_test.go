@@ -0,0 +1,218 @@
+package dht
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+func TestState_SaveLoad(t *testing.T) {
+	dhtNodeID := bits.Rand()
+	conn := newTestUDPConn("127.0.0.1:21217")
+
+	dht := New(&Config{
+		Address:   "127.0.0.1:21216",
+		NodeID:    dhtNodeID.Hex(),
+		StateFile: filepath.Join(t.TempDir(), "dht.state"),
+	})
+
+	err := dht.connect(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dht.node.Shutdown()
+
+	var contacts []Contact
+	for i := 0; i < 3; i++ {
+		c := Contact{ID: bits.Rand(), IP: net.ParseIP("127.0.0.1"), Port: 10000 + i}
+		contacts = append(contacts, c)
+		dht.node.rt.Update(c)
+	}
+
+	hashes := []bits.Bitmap{bits.Rand(), bits.Rand()}
+	for _, h := range hashes {
+		dht.scheduler.Add(h)
+	}
+
+	err = dht.saveState()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := loadState(dht.conf.StateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state == nil {
+		t.Fatal("state file was not found")
+	}
+
+	if state.nodeID != dhtNodeID.Hex() {
+		t.Errorf("expected node id %s, got %s", dhtNodeID.Hex(), state.nodeID)
+	}
+
+	if len(state.contacts) != len(contacts) {
+		t.Fatalf("expected %d contacts, got %d", len(contacts), len(state.contacts))
+	}
+	for _, c := range contacts {
+		found := false
+		for _, loaded := range state.contacts {
+			if loaded.Equals(c, true) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("contact %s was not saved", c.String())
+		}
+	}
+
+	if len(state.hashes) != len(hashes) {
+		t.Fatalf("expected %d hashes, got %d", len(hashes), len(state.hashes))
+	}
+	for _, h := range hashes {
+		found := false
+		for _, loaded := range state.hashes {
+			if loaded.Equals(h) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("hash %s was not saved", h.HexShort())
+		}
+	}
+}
+
+func TestState_LoadMissingFile(t *testing.T) {
+	state, err := loadState(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != nil {
+		t.Error("expected no state for a missing file")
+	}
+}
+
+func TestDHT_RestartFromState(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping slow restart test")
+	}
+
+	bs, dhts := TestingCreateNetwork(t, 2, true, false)
+	defer func() {
+		for i := range dhts {
+			dhts[i].Shutdown()
+		}
+		bs.Shutdown()
+	}()
+
+	// a state file from a previous run that knows about one of the network nodes
+	known := dhts[0].contact
+	hash := bits.Rand()
+	rt, err := json.Marshal(rtSave{
+		ID:       bits.Rand().Hex(),
+		Contacts: []string{strings.Join([]string{known.ID.Hex(), known.IP.String(), strconv.Itoa(known.Port)}, rtContactSep)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := json.Marshal(stateSave{RoutingTable: rt, Hashes: []string{hash.Hex()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "dht.state")
+	err = os.WriteFile(stateFile, state, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := NewStandardConfig()
+	c.Address = testingDHTIP + ":" + strconv.Itoa(testingDHTFirstPort+10)
+	c.SeedNodes = nil // joining must work with only the saved contacts
+	c.StateFile = stateFile
+	d := New(c)
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.node.rt.GetClosest(known.ID, 1)) != 1 || !d.node.rt.GetClosest(known.ID, 1)[0].ID.Equals(known.ID) {
+		t.Error("saved contact was not re-added to the routing table")
+	}
+
+	for i := 0; i < 10 && len(d.announcedHashes()) == 0; i++ {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if hashes := d.announcedHashes(); len(hashes) != 1 || !hashes[0].Equals(hash) {
+		t.Errorf("saved hash was not re-added to the announce queue")
+	}
+
+	d.Shutdown()
+
+	saved, err := loadState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.nodeID != d.node.id.Hex() {
+		t.Error("node id should be kept across restarts")
+	}
+	if len(saved.contacts) < 1 {
+		t.Error("routing table was not saved on shutdown")
+	}
+	if len(saved.hashes) != 1 {
+		t.Error("announced hashes were not saved on shutdown")
+	}
+}
+
+func TestDHT_ShutdownKeepsRestoredHashes(t *testing.T) {
+	rt, err := json.Marshal(rtSave{ID: bits.Rand().Hex(), Contacts: []string{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string]bool)
+	save := stateSave{RoutingTable: rt}
+	for i := 0; i < 1000; i++ {
+		h := bits.Rand().Hex()
+		hashes[h] = true
+		save.Hashes = append(save.Hashes, h)
+	}
+	state, err := json.Marshal(save)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stateFile := filepath.Join(t.TempDir(), "dht.state")
+	err = os.WriteFile(stateFile, state, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(&Config{Address: "127.0.0.1:21318", StateFile: stateFile})
+	err = d.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Shutdown()
+
+	saved, err := loadState(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.hashes) != len(hashes) {
+		t.Fatalf("expected %d saved hashes, got %d", len(hashes), len(saved.hashes))
+	}
+	for _, h := range saved.hashes {
+		if !hashes[h.Hex()] {
+			t.Errorf("unexpected hash %s was saved", h.HexShort())
+		}
+	}
+}
@@ -1,46 +1,120 @@
 package dht
 
 import (
+	"net"
 	"sync"
+	"time"
 
 	"github.com/lbryio/lbry.go/v2/dht/bits"
 )
 
-// TODO: expire stored data after tExpire time
+// PeerStore stores the peers that other nodes announce to this node, by the hash they announced
+type PeerStore interface {
+	// Upsert stores the contact for the hash at storedAt, or moves the time forward if the contact is already stored for
+	// the hash. It returns ErrStoreLimit without storing anything if the hash is full or the contact's IP has too many
+	// stored pairs.
+	Upsert(hash bits.Bitmap, contact Contact, storedAt time.Time) error
+	// Get returns the contacts storing the hash that have not expired
+	Get(hash bits.Bitmap) ([]Contact, error)
+	// Remove removes the contact from every hash it is stored for
+	Remove(contact Contact) error
+	// RemoveExpired removes every (hash, contact) pair that has not been refreshed within the expiration time, and
+	// forgets contacts that are no longer storing any hashes. It returns the number of pairs removed.
+	RemoveExpired() (int, error)
+	// CountStoredHashes returns the number of hashes with at least one contact
+	CountStoredHashes() int
+	// CountStoredPeers returns the number of distinct contacts storing hashes
+	CountStoredPeers() int
+	// CountStoredForIP returns the number of (hash, contact) pairs stored by contacts at the ip
+	CountStoredForIP(ip net.IP) int
+	// Close releases the resources of the store. The node closes its store when it shuts down.
+	Close() error
+}
+
+// PeerStoreOptions are the expiration and limits that a PeerStore enforces
+type PeerStoreOptions struct {
+	// stored peers are forgotten if they don't reannounce within this time. zero means never
+	Expiration time.Duration
+	// the most contacts stored for one hash. zero means no limit
+	MaxPeersPerHash int
+	// the most (hash, contact) pairs stored from one IP. zero means no limit
+	MaxStoresPerIP int
+}
 
-type contactStore struct {
-	// map of blob hashes to (map of node IDs to bools)
-	hashes map[bits.Bitmap]map[bits.Bitmap]bool
+func (o PeerStoreOptions) isExpired(storedAt time.Time) bool {
+	return o.Expiration > 0 && time.Since(storedAt) > o.Expiration
+}
+
+// MemoryPeerStore is a PeerStore that keeps everything in memory. It is the default store.
+type MemoryPeerStore struct {
+	// map of blob hashes to (map of node IDs to the time that node last stored the hash)
+	hashes map[bits.Bitmap]map[bits.Bitmap]time.Time
 	// stores the peers themselves, so they can be updated in one place
 	contacts map[bits.Bitmap]Contact
+	// the number of hashes each contact is storing, and the number of hash/contact pairs from each IP
+	idCounts map[bits.Bitmap]int
+	ipCounts map[string]int
+	opts     PeerStoreOptions
 	lock     sync.RWMutex
 }
 
-func newStore() *contactStore {
-	return &contactStore{
-		hashes:   make(map[bits.Bitmap]map[bits.Bitmap]bool),
+// NewMemoryPeerStore returns an empty in-memory store
+func NewMemoryPeerStore(opts PeerStoreOptions) *MemoryPeerStore {
+	return &MemoryPeerStore{
+		hashes:   make(map[bits.Bitmap]map[bits.Bitmap]time.Time),
 		contacts: make(map[bits.Bitmap]Contact),
+		idCounts: make(map[bits.Bitmap]int),
+		ipCounts: make(map[string]int),
+		opts:     opts,
 	}
 }
 
-func (s *contactStore) Upsert(blobHash bits.Bitmap, contact Contact) {
+// Upsert implements PeerStore
+func (s *MemoryPeerStore) Upsert(blobHash bits.Bitmap, contact Contact, storedAt time.Time) error {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
-	if _, ok := s.hashes[blobHash]; !ok {
-		s.hashes[blobHash] = make(map[bits.Bitmap]bool)
+	ids := s.hashes[blobHash]
+	_, refresh := ids[contact.ID]
+	if !refresh {
+		if s.opts.MaxPeersPerHash > 0 && len(ids) >= s.opts.MaxPeersPerHash {
+			return ErrStoreLimit
+		}
+		if s.opts.MaxStoresPerIP > 0 && s.ipCounts[contact.IP.String()] >= s.opts.MaxStoresPerIP {
+			return ErrStoreLimit
+		}
+	}
+
+	// if the contact moved, the pairs it stores now count against its new IP
+	if old, ok := s.contacts[contact.ID]; ok && !old.IP.Equal(contact.IP) {
+		s.decrementIP(old.IP, s.idCounts[contact.ID])
+		s.ipCounts[contact.IP.String()] += s.idCounts[contact.ID]
+	}
+
+	if ids == nil {
+		ids = make(map[bits.Bitmap]time.Time)
+		s.hashes[blobHash] = ids
+	}
+	if !refresh {
+		s.idCounts[contact.ID]++
+		s.ipCounts[contact.IP.String()]++
 	}
-	s.hashes[blobHash][contact.ID] = true
+	ids[contact.ID] = storedAt
 	s.contacts[contact.ID] = contact
+	return nil
 }
 
-func (s *contactStore) Get(blobHash bits.Bitmap) []Contact {
+// Get implements PeerStore
+func (s *MemoryPeerStore) Get(blobHash bits.Bitmap) ([]Contact, error) {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 
 	var contacts []Contact
 	if ids, ok := s.hashes[blobHash]; ok {
-		for id := range ids {
+		for id, storedAt := range ids {
+			if s.opts.isExpired(storedAt) {
+				continue // the sweeper will remove it soon
+			}
 			contact, ok := s.contacts[id]
 			if !ok {
 				panic("node id in IDs list, but not in nodeInfo")
@@ -48,15 +122,96 @@ func (s *contactStore) Get(blobHash bits.Bitmap) []Contact {
 			contacts = append(contacts, contact)
 		}
 	}
-	return contacts
+	return contacts, nil
 }
 
-func (s *contactStore) RemoveTODO(contact Contact) {
-	// TODO: remove peer from everywhere
+// Remove implements PeerStore
+func (s *MemoryPeerStore) Remove(contact Contact) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for hash, ids := range s.hashes {
+		delete(ids, contact.ID)
+		if len(ids) == 0 {
+			delete(s.hashes, hash)
+		}
+	}
+	if stored, ok := s.contacts[contact.ID]; ok {
+		s.decrementIP(stored.IP, s.idCounts[contact.ID])
+	}
+	delete(s.idCounts, contact.ID)
+	delete(s.contacts, contact.ID)
+	return nil
 }
 
-func (s *contactStore) CountStoredHashes() int {
+// RemoveExpired implements PeerStore
+func (s *MemoryPeerStore) RemoveExpired() (int, error) {
+	if s.opts.Expiration <= 0 {
+		return 0, nil
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	removed := 0
+	stillStoring := make(map[bits.Bitmap]bool)
+	for hash, ids := range s.hashes {
+		for id, storedAt := range ids {
+			if s.opts.isExpired(storedAt) {
+				delete(ids, id)
+				s.decrementIP(s.contacts[id].IP, 1)
+				if s.idCounts[id]--; s.idCounts[id] <= 0 {
+					delete(s.idCounts, id)
+				}
+				removed++
+			} else {
+				stillStoring[id] = true
+			}
+		}
+		if len(ids) == 0 {
+			delete(s.hashes, hash)
+		}
+	}
+
+	for id := range s.contacts {
+		if !stillStoring[id] {
+			delete(s.contacts, id)
+		}
+	}
+
+	return removed, nil
+}
+
+// CountStoredHashes implements PeerStore
+func (s *MemoryPeerStore) CountStoredHashes() int {
 	s.lock.RLock()
 	defer s.lock.RUnlock()
 	return len(s.hashes)
 }
+
+// CountStoredPeers implements PeerStore
+func (s *MemoryPeerStore) CountStoredPeers() int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.contacts)
+}
+
+// CountStoredForIP implements PeerStore
+func (s *MemoryPeerStore) CountStoredForIP(ip net.IP) int {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.ipCounts[ip.String()]
+}
+
+// Close implements PeerStore. It does nothing.
+func (s *MemoryPeerStore) Close() error {
+	return nil
+}
+
+// decrementIP lowers the number of pairs stored from the ip. the lock must be held
+func (s *MemoryPeerStore) decrementIP(ip net.IP, by int) {
+	key := ip.String()
+	if s.ipCounts[key] -= by; s.ipCounts[key] <= 0 {
+		delete(s.ipCounts, key)
+	}
+}
@@ -0,0 +1,377 @@
+package dht
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// the buckets in a BoltPeerStore file. hashes and node IDs are nodeIDLength bytes, times are big-endian unix nanoseconds
+// so they sort in order, and IPs are 16 bytes
+var (
+	// hash + node ID -> time the pair was stored
+	boltPeersBucket = []byte("peers")
+	// time + hash + node ID -> nothing. lets expired pairs be found without looking at the rest
+	boltExpiryBucket = []byte("expiry")
+	// node ID + hash -> nothing. lets a contact be removed without looking at every hash
+	boltByIDBucket = []byte("by_id")
+	// node ID -> IP, port, peer port and the number of hashes the contact stores
+	boltContactsBucket = []byte("contacts")
+	// IP -> the number of pairs stored by contacts at the IP
+	boltIPsBucket = []byte("ips")
+	// counters that would be slow to compute
+	boltMetaBucket = []byte("meta")
+)
+
+var (
+	boltMetaHashes = []byte("hashes")
+	boltMetaPeers  = []byte("peers")
+)
+
+// boltContactLength is the length of a value in the contacts bucket
+const boltContactLength = net.IPv6len + 2 + 2 + 4
+
+// BoltPeerStore is a PeerStore that keeps everything in a bolt database file, so stored peers survive restarts and the
+// number of peers is not limited by memory
+type BoltPeerStore struct {
+	db   *bolt.DB
+	opts PeerStoreOptions
+}
+
+// OpenBoltPeerStore opens the store in the file at path, creating it if it does not exist
+func OpenBoltPeerStore(path string, opts PeerStoreOptions) (*BoltPeerStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Prefix("opening peer store", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltPeersBucket, boltExpiryBucket, boltByIDBucket, boltContactsBucket, boltIPsBucket, boltMetaBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return errors.Err(err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltPeerStore{db: db, opts: opts}, nil
+}
+
+// Upsert implements PeerStore
+func (s *BoltPeerStore) Upsert(hash bits.Bitmap, contact Contact, storedAt time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		peers := tx.Bucket(boltPeersBucket)
+		expiry := tx.Bucket(boltExpiryBucket)
+		contacts := tx.Bucket(boltContactsBucket)
+		ips := tx.Bucket(boltIPsBucket)
+		meta := tx.Bucket(boltMetaBucket)
+
+		key := concat(hash[:], contact.ID[:])
+		oldTime := copyBytes(peers.Get(key))
+		refresh := oldTime != nil
+
+		var old Contact
+		var count uint32
+		stored := contacts.Get(contact.ID[:])
+		if stored != nil {
+			old, count = decodeBoltContact(contact.ID, stored)
+		}
+
+		if !refresh {
+			if s.opts.MaxPeersPerHash > 0 && countPrefix(peers, hash[:], s.opts.MaxPeersPerHash) >= s.opts.MaxPeersPerHash {
+				return ErrStoreLimit
+			}
+			if s.opts.MaxStoresPerIP > 0 && getCounter(ips, ipKey(contact.IP)) >= uint64(s.opts.MaxStoresPerIP) {
+				return ErrStoreLimit
+			}
+		}
+
+		// if the contact moved, the pairs it stores now count against its new IP
+		if stored != nil && !old.IP.Equal(contact.IP) {
+			if err := addCounter(ips, ipKey(old.IP), -int64(count)); err != nil {
+				return err
+			}
+			if err := addCounter(ips, ipKey(contact.IP), int64(count)); err != nil {
+				return err
+			}
+		}
+
+		if refresh {
+			if err := expiry.Delete(concat(oldTime, key)); err != nil {
+				return errors.Err(err)
+			}
+		} else {
+			if countPrefix(peers, hash[:], 1) == 0 {
+				if err := addCounter(meta, boltMetaHashes, 1); err != nil {
+					return err
+				}
+			}
+			if stored == nil {
+				if err := addCounter(meta, boltMetaPeers, 1); err != nil {
+					return err
+				}
+			}
+			if err := tx.Bucket(boltByIDBucket).Put(concat(contact.ID[:], hash[:]), nil); err != nil {
+				return errors.Err(err)
+			}
+			if err := addCounter(ips, ipKey(contact.IP), 1); err != nil {
+				return err
+			}
+			count++
+		}
+
+		t := encodeTime(storedAt)
+		if err := peers.Put(key, t); err != nil {
+			return errors.Err(err)
+		}
+		if err := expiry.Put(concat(t, key), nil); err != nil {
+			return errors.Err(err)
+		}
+		return errors.Err(contacts.Put(contact.ID[:], encodeBoltContact(contact, count)))
+	})
+}
+
+// Get implements PeerStore
+func (s *BoltPeerStore) Get(hash bits.Bitmap) ([]Contact, error) {
+	var found []Contact
+	err := s.db.View(func(tx *bolt.Tx) error {
+		contacts := tx.Bucket(boltContactsBucket)
+		c := tx.Bucket(boltPeersBucket).Cursor()
+		for k, v := c.Seek(hash[:]); k != nil && bytes.HasPrefix(k, hash[:]); k, v = c.Next() {
+			if s.opts.isExpired(decodeTime(v)) {
+				continue // the sweeper will remove it soon
+			}
+			id := bits.FromBytesP(k[nodeIDLength:])
+			stored := contacts.Get(id[:])
+			if stored == nil {
+				return errors.Err("node id %s stored for hash, but has no contact", id.HexShort())
+			}
+			contact, _ := decodeBoltContact(id, stored)
+			found = append(found, contact)
+		}
+		return nil
+	})
+	return found, err
+}
+
+// Remove implements PeerStore
+func (s *BoltPeerStore) Remove(contact Contact) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		var hashes []bits.Bitmap
+		c := tx.Bucket(boltByIDBucket).Cursor()
+		for k, _ := c.Seek(contact.ID[:]); k != nil && bytes.HasPrefix(k, contact.ID[:]); k, _ = c.Next() {
+			hashes = append(hashes, bits.FromBytesP(k[nodeIDLength:]))
+		}
+
+		for _, hash := range hashes {
+			if err := removeBoltPair(tx, hash, contact.ID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveExpired implements PeerStore
+func (s *BoltPeerStore) RemoveExpired() (int, error) {
+	if s.opts.Expiration <= 0 {
+		return 0, nil
+	}
+
+	cutoff := encodeTime(time.Now().Add(-s.opts.Expiration))
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		var keys [][]byte
+		c := tx.Bucket(boltExpiryBucket).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k[:len(cutoff)], cutoff) < 0; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k[len(cutoff):]...))
+		}
+
+		for _, k := range keys {
+			hash, id := bits.FromBytesP(k[:nodeIDLength]), bits.FromBytesP(k[nodeIDLength:])
+			if err := removeBoltPair(tx, hash, id); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// CountStoredHashes implements PeerStore
+func (s *BoltPeerStore) CountStoredHashes() int {
+	return s.metaCounter(boltMetaHashes)
+}
+
+// CountStoredPeers implements PeerStore
+func (s *BoltPeerStore) CountStoredPeers() int {
+	return s.metaCounter(boltMetaPeers)
+}
+
+// CountStoredForIP implements PeerStore
+func (s *BoltPeerStore) CountStoredForIP(ip net.IP) int {
+	var count uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = getCounter(tx.Bucket(boltIPsBucket), ipKey(ip))
+		return nil
+	})
+	if err != nil {
+		log.Error(errors.Prefix("counting stored peers for ip", err))
+	}
+	return int(count)
+}
+
+// Close implements PeerStore
+func (s *BoltPeerStore) Close() error {
+	return errors.Err(s.db.Close())
+}
+
+func (s *BoltPeerStore) metaCounter(name []byte) int {
+	var count uint64
+	err := s.db.View(func(tx *bolt.Tx) error {
+		count = getCounter(tx.Bucket(boltMetaBucket), name)
+		return nil
+	})
+	if err != nil {
+		log.Error(errors.Prefix("reading peer store counter", err))
+	}
+	return int(count)
+}
+
+// removeBoltPair removes a (hash, contact) pair and updates the counters. the contact is forgotten if this was the last
+// hash it was storing.
+func removeBoltPair(tx *bolt.Tx, hash, id bits.Bitmap) error {
+	peers := tx.Bucket(boltPeersBucket)
+	contacts := tx.Bucket(boltContactsBucket)
+	meta := tx.Bucket(boltMetaBucket)
+
+	key := concat(hash[:], id[:])
+	t := copyBytes(peers.Get(key))
+	if t == nil {
+		return nil
+	}
+	if err := tx.Bucket(boltExpiryBucket).Delete(concat(t, key)); err != nil {
+		return errors.Err(err)
+	}
+	if err := peers.Delete(key); err != nil {
+		return errors.Err(err)
+	}
+	if err := tx.Bucket(boltByIDBucket).Delete(concat(id[:], hash[:])); err != nil {
+		return errors.Err(err)
+	}
+	if countPrefix(peers, hash[:], 1) == 0 {
+		if err := addCounter(meta, boltMetaHashes, -1); err != nil {
+			return err
+		}
+	}
+
+	stored := contacts.Get(id[:])
+	if stored == nil {
+		return nil
+	}
+	contact, count := decodeBoltContact(id, stored)
+	if err := addCounter(tx.Bucket(boltIPsBucket), ipKey(contact.IP), -1); err != nil {
+		return err
+	}
+	if count <= 1 {
+		if err := contacts.Delete(id[:]); err != nil {
+			return errors.Err(err)
+		}
+		return addCounter(meta, boltMetaPeers, -1)
+	}
+	return errors.Err(contacts.Put(id[:], encodeBoltContact(contact, count-1)))
+}
+
+// countPrefix counts the keys in the bucket that start with prefix, stopping once it reaches limit
+func countPrefix(b *bolt.Bucket, prefix []byte, limit int) int {
+	count := 0
+	c := b.Cursor()
+	for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix) && count < limit; k, _ = c.Next() {
+		count++
+	}
+	return count
+}
+
+func getCounter(b *bolt.Bucket, key []byte) uint64 {
+	v := b.Get(key)
+	if len(v) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(v)
+}
+
+// addCounter adds delta to a counter. counters that reach zero are deleted
+func addCounter(b *bolt.Bucket, key []byte, delta int64) error {
+	n := int64(getCounter(b, key)) + delta
+	if n <= 0 {
+		return errors.Err(b.Delete(key))
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(n))
+	return errors.Err(b.Put(key, v))
+}
+
+func encodeBoltContact(c Contact, count uint32) []byte {
+	v := make([]byte, boltContactLength)
+	copy(v, c.IP.To16())
+	binary.BigEndian.PutUint16(v[16:], uint16(c.Port))
+	binary.BigEndian.PutUint16(v[18:], uint16(c.PeerPort))
+	binary.BigEndian.PutUint32(v[20:], count)
+	return v
+}
+
+func decodeBoltContact(id bits.Bitmap, v []byte) (Contact, uint32) {
+	if len(v) != boltContactLength {
+		return Contact{ID: id}, 0
+	}
+	ip := net.IP(append([]byte(nil), v[:16]...))
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return Contact{
+		ID:       id,
+		IP:       ip,
+		Port:     int(binary.BigEndian.Uint16(v[16:])),
+		PeerPort: int(binary.BigEndian.Uint16(v[18:])),
+	}, binary.BigEndian.Uint32(v[20:])
+}
+
+func ipKey(ip net.IP) []byte {
+	key := make([]byte, net.IPv6len)
+	copy(key, ip.To16())
+	return key
+}
+
+func encodeTime(t time.Time) []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, uint64(t.UnixNano()))
+	return v
+}
+
+func decodeTime(v []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(v)))
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
+
+// concat returns a new slice with the parts joined together. bolt needs keys that are not modified until the
+// transaction ends, so keys are never built by appending to a shared slice
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
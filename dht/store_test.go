@@ -0,0 +1,291 @@
+package dht
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/dht/bits"
+)
+
+// testPeerStores runs the test against every PeerStore implementation
+func testPeerStores(t *testing.T, opts PeerStoreOptions, test func(t *testing.T, s PeerStore)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryPeerStore(opts))
+	})
+	t.Run("bolt", func(t *testing.T) {
+		s, err := OpenBoltPeerStore(filepath.Join(t.TempDir(), "peers.db"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		test(t, s)
+	})
+}
+
+func mustGet(t *testing.T, s PeerStore, hash bits.Bitmap) []Contact {
+	t.Helper()
+	contacts, err := s.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contacts
+}
+
+func mustUpsert(t *testing.T, s PeerStore, hash bits.Bitmap, c Contact, storedAt time.Time) {
+	t.Helper()
+	err := s.Upsert(hash, c, storedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStore_Expiration(t *testing.T) {
+	testPeerStores(t, PeerStoreOptions{Expiration: time.Hour}, func(t *testing.T, s PeerStore) {
+		hash := bits.Rand()
+		fresh := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), PeerPort: 3333}
+		stale := Contact{ID: bits.Rand(), IP: net.ParseIP("5.6.7.8"), PeerPort: 3333}
+		longAgo := time.Now().Add(-2 * time.Hour)
+
+		mustUpsert(t, s, hash, fresh, time.Now())
+		mustUpsert(t, s, hash, stale, longAgo)
+
+		otherHash := bits.Rand()
+		mustUpsert(t, s, otherHash, stale, longAgo)
+
+		contacts := mustGet(t, s, hash)
+		if len(contacts) != 1 || !contacts[0].ID.Equals(fresh.ID) {
+			t.Fatalf("expected only the fresh contact, got %v", contacts)
+		}
+
+		removed, err := s.RemoveExpired()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 2 {
+			t.Errorf("expected 2 expired entries, got %d", removed)
+		}
+		if s.CountStoredHashes() != 1 {
+			t.Errorf("expected 1 stored hash after expiration, got %d", s.CountStoredHashes())
+		}
+		if s.CountStoredPeers() != 1 {
+			t.Errorf("stale contact should have been forgotten, got %d stored peers", s.CountStoredPeers())
+		}
+		if s.CountStoredForIP(stale.IP) != 0 {
+			t.Errorf("expected no pairs for the stale contact's IP, got %d", s.CountStoredForIP(stale.IP))
+		}
+
+		// reannouncing refreshes the timestamp
+		mustUpsert(t, s, otherHash, stale, time.Now())
+		if len(mustGet(t, s, otherHash)) != 1 {
+			t.Error("reannounced contact should be returned")
+		}
+
+		// and a refreshed pair does not expire
+		mustUpsert(t, s, hash, fresh, longAgo)
+		mustUpsert(t, s, hash, fresh, time.Now())
+		removed, err = s.RemoveExpired()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 0 {
+			t.Errorf("expected nothing to expire after reannouncing, got %d", removed)
+		}
+	})
+}
+
+func TestStore_NoExpiration(t *testing.T) {
+	testPeerStores(t, PeerStoreOptions{}, func(t *testing.T, s PeerStore) {
+		hash := bits.Rand()
+		c := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), PeerPort: 3333}
+		mustUpsert(t, s, hash, c, time.Now().Add(-24*time.Hour))
+
+		removed, err := s.RemoveExpired()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if removed != 0 {
+			t.Error("nothing should expire when expiration is disabled")
+		}
+		if len(mustGet(t, s, hash)) != 1 {
+			t.Error("contact should still be returned")
+		}
+	})
+}
+
+func TestStore_Remove(t *testing.T) {
+	testPeerStores(t, PeerStoreOptions{Expiration: time.Hour}, func(t *testing.T, s PeerStore) {
+		c := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), PeerPort: 3333}
+		other := Contact{ID: bits.Rand(), IP: net.ParseIP("5.6.7.8"), PeerPort: 3333}
+
+		hashes := []bits.Bitmap{bits.Rand(), bits.Rand(), bits.Rand()}
+		for _, h := range hashes {
+			mustUpsert(t, s, h, c, time.Now())
+		}
+		mustUpsert(t, s, hashes[0], other, time.Now())
+
+		err := s.Remove(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if s.CountStoredHashes() != 1 {
+			t.Errorf("expected 1 stored hash, got %d", s.CountStoredHashes())
+		}
+		for _, h := range hashes {
+			for _, stored := range mustGet(t, s, h) {
+				if stored.ID.Equals(c.ID) {
+					t.Errorf("removed contact is still stored for %s", h.HexShort())
+				}
+			}
+		}
+		if len(mustGet(t, s, hashes[0])) != 1 {
+			t.Error("other contact should not have been removed")
+		}
+	})
+}
+
+func TestStore_Contact(t *testing.T) {
+	testPeerStores(t, PeerStoreOptions{}, func(t *testing.T, s PeerStore) {
+		hash := bits.Rand()
+		v4 := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444, PeerPort: 3333}
+		v6 := Contact{ID: bits.Rand(), IP: net.ParseIP("2001:db8::1"), Port: 4445, PeerPort: 3334}
+		mustUpsert(t, s, hash, v4, time.Now())
+		mustUpsert(t, s, hash, v6, time.Now())
+
+		contacts := mustGet(t, s, hash)
+		if len(contacts) != 2 {
+			t.Fatalf("expected 2 contacts, got %d", len(contacts))
+		}
+		for _, c := range contacts {
+			expected := v4
+			if c.ID.Equals(v6.ID) {
+				expected = v6
+			}
+			if !c.IP.Equal(expected.IP) || c.Port != expected.Port || c.PeerPort != expected.PeerPort {
+				t.Errorf("expected %s, got %s", expected, c)
+			}
+		}
+		if s.CountStoredPeers() != 2 {
+			t.Errorf("expected 2 stored peers, got %d", s.CountStoredPeers())
+		}
+	})
+}
+
+func TestStore_Limits(t *testing.T) {
+	testPeerStores(t, PeerStoreOptions{MaxPeersPerHash: 2, MaxStoresPerIP: 3}, func(t *testing.T, s PeerStore) {
+		ip := net.ParseIP("1.2.3.4")
+		hash := bits.Rand()
+		a := Contact{ID: bits.Rand(), IP: ip, PeerPort: 3333}
+		b := Contact{ID: bits.Rand(), IP: ip, PeerPort: 3334}
+		c := Contact{ID: bits.Rand(), IP: net.ParseIP("5.6.7.8"), PeerPort: 3333}
+		now := time.Now()
+
+		if s.Upsert(hash, a, now) != nil || s.Upsert(hash, b, now) != nil {
+			t.Fatal("expected the first two peers to be stored")
+		}
+		if err := s.Upsert(hash, c, now); err != ErrStoreLimit {
+			t.Errorf("expected the hash to be full, got %v", err)
+		}
+		if err := s.Upsert(hash, a, now); err != nil {
+			t.Errorf("a stored peer should always be able to reannounce, got %v", err)
+		}
+
+		if s.Upsert(bits.Rand(), a, now) != nil {
+			t.Fatal("expected the third pair from the IP to be stored")
+		}
+		if err := s.Upsert(bits.Rand(), b, now); err != ErrStoreLimit {
+			t.Errorf("expected the IP to be over its limit, got %v", err)
+		}
+		if s.CountStoredForIP(ip) != 3 {
+			t.Errorf("expected 3 pairs from the IP, got %d", s.CountStoredForIP(ip))
+		}
+
+		// moving to a new IP takes the pairs along
+		moved := a
+		moved.IP = net.ParseIP("9.9.9.9")
+		if s.Upsert(hash, moved, now) != nil {
+			t.Fatal("expected the peer to be updated")
+		}
+		if s.CountStoredForIP(ip) != 1 || s.CountStoredForIP(moved.IP) != 2 {
+			t.Errorf("expected pairs to move with the peer, got %d and %d", s.CountStoredForIP(ip), s.CountStoredForIP(moved.IP))
+		}
+
+		if err := s.Remove(moved); err != nil {
+			t.Fatal(err)
+		}
+		if s.CountStoredForIP(moved.IP) != 0 {
+			t.Errorf("expected no pairs from a removed peer, got %d", s.CountStoredForIP(moved.IP))
+		}
+		if s.Upsert(bits.Rand(), b, now) != nil {
+			t.Error("expected room for the IP after a peer was removed")
+		}
+	})
+}
+
+func TestBoltPeerStore_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.db")
+	opts := PeerStoreOptions{Expiration: time.Hour}
+
+	s, err := OpenBoltPeerStore(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := bits.Rand()
+	c := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444, PeerPort: 3333}
+	mustUpsert(t, s, hash, c, time.Now())
+	err = s.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenBoltPeerStore(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	contacts := mustGet(t, s, hash)
+	if len(contacts) != 1 || !contacts[0].ID.Equals(c.ID) {
+		t.Errorf("expected the stored contact after reopening, got %v", contacts)
+	}
+	if s.CountStoredHashes() != 1 || s.CountStoredPeers() != 1 {
+		t.Errorf("expected counts to survive reopening, got %d hashes and %d peers", s.CountStoredHashes(), s.CountStoredPeers())
+	}
+}
+
+func TestDHT_PeerStoreFile(t *testing.T) {
+	conf := &Config{
+		Address:       "127.0.0.1:21216",
+		NodeID:        bits.Rand().Hex(),
+		PeerStoreFile: filepath.Join(t.TempDir(), "peers.db"),
+	}
+	hash := bits.Rand()
+	c := Contact{ID: bits.Rand(), IP: net.ParseIP("1.2.3.4"), Port: 4444, PeerPort: 3333}
+
+	d := New(conf)
+	err := d.connect(newTestUDPConn("127.0.0.1:21217"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.node.store.(*BoltPeerStore); !ok {
+		t.Fatalf("expected a bolt store, got %T", d.node.store)
+	}
+	err = d.node.Store(hash, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Shutdown()
+
+	// the stored peer is still there after a restart
+	d = New(conf)
+	err = d.connect(newTestUDPConn("127.0.0.1:21217"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Shutdown()
+	if contacts := mustGet(t, d.node.store, hash); len(contacts) != 1 || !contacts[0].ID.Equals(c.ID) {
+		t.Errorf("expected the stored peer after restarting, got %v", contacts)
+	}
+}
@@ -16,6 +16,12 @@ var testingDHTFirstPort = 21000
 
 // TestingCreateNetwork initializes a testable DHT network with a specific number of nodes, with bootstrap and concurrent options.
 func TestingCreateNetwork(t *testing.T, numNodes int, bootstrap, concurrent bool) (*BootstrapNode, []*DHT) {
+	return TestingCreateNetworkWithConfig(t, numNodes, bootstrap, concurrent, NewStandardConfig())
+}
+
+// TestingCreateNetworkWithConfig is like TestingCreateNetwork, but every node gets a copy of conf. The node ID, address,
+// and seed nodes in conf are ignored. Requests are not rate limited, since all the nodes share an IP.
+func TestingCreateNetworkWithConfig(t *testing.T, numNodes int, bootstrap, concurrent bool, conf *Config) (*BootstrapNode, []*DHT) {
 	var bootstrapNode *BootstrapNode
 	var seeds []string
 
@@ -23,6 +29,7 @@ func TestingCreateNetwork(t *testing.T, numNodes int, bootstrap, concurrent bool
 		bootstrapAddress := testingDHTIP + ":" + strconv.Itoa(testingDHTFirstPort)
 		seeds = []string{bootstrapAddress}
 		bootstrapNode = NewBootstrapNode(bits.Rand(), 0, bootstrapDefaultRefreshDuration)
+		bootstrapNode.limiter = nil
 		listener, err := net.ListenPacket(Network, bootstrapAddress)
 		if err != nil {
 			panic(err)
@@ -42,10 +49,12 @@ func TestingCreateNetwork(t *testing.T, numNodes int, bootstrap, concurrent bool
 	dhts := make([]*DHT, numNodes)
 
 	for i := 0; i < numNodes; i++ {
-		c := NewStandardConfig()
+		c := new(Config)
+		*c = *conf
 		c.NodeID = bits.Rand().Hex()
 		c.Address = testingDHTIP + ":" + strconv.Itoa(firstPort+i)
 		c.SeedNodes = seeds
+		c.RequestRate = 0
 		dht := New(c)
 
 		go func() {
@@ -106,7 +115,7 @@ func newTestUDPConn(addr string) *testUDPConn {
 		panic(err)
 	}
 	return &testUDPConn{
-		addr:   &net.UDPAddr{IP: net.IP(parts[0]), Port: port},
+		addr:   &net.UDPAddr{IP: net.ParseIP(parts[0]), Port: port},
 		toRead: make(chan testUDPPacket),
 		writes: make(chan testUDPPacket),
 	}
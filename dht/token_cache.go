@@ -31,8 +31,6 @@ func newTokenCache(node *Node, expiration time.Duration) *tokenCache {
 	return tc
 }
 
-// TODO: if store fails, get new token. can happen if a node restarts but we have the token cached
-
 func (tc *tokenCache) Get(c Contact, hash bits.Bitmap, cancelCh stop.Chan) string {
 	tc.lock.RLock()
 	token, exists := tc.tokens[c.String()]
@@ -68,3 +66,11 @@ func (tc *tokenCache) Get(c Contact, hash bits.Bitmap, cancelCh stop.Chan) strin
 
 	return res.Token
 }
+
+// Invalidate forgets the cached token for the contact, so the next Get fetches a new one. This is needed when a
+// contact rejects a cached token, which can happen if it restarted.
+func (tc *tokenCache) Invalidate(c Contact) {
+	tc.lock.Lock()
+	defer tc.lock.Unlock()
+	delete(tc.tokens, c.String())
+}
@@ -16,13 +16,29 @@ import (
 type tokenManager struct {
 	secret     []byte
 	prevSecret []byte
+	interval   time.Duration
+	rotatedAt  time.Time
+	rotations  int
 	lock       *sync.RWMutex
 	stop       *stop.Group
 }
 
+// TokenStatus describes the rotation of the secret that store tokens are made from. The secret itself is not exposed.
+type TokenStatus struct {
+	// how often the secret is rotated. tokens made from the previous secret are still accepted
+	Interval time.Duration
+	// when the secret was last rotated
+	LastRotation time.Time
+	// when the secret will be rotated next
+	NextRotation time.Time
+	// the number of times the secret was rotated since the node started, including the first secret
+	Rotations int
+}
+
 func (tm *tokenManager) Start(interval time.Duration) {
 	tm.secret = make([]byte, 64)
 	tm.prevSecret = make([]byte, 64)
+	tm.interval = interval
 	tm.lock = &sync.RWMutex{}
 	tm.stop = stop.New()
 
@@ -75,4 +91,27 @@ func (tm *tokenManager) rotateSecret() {
 	if err != nil {
 		panic(err)
 	}
+	tm.rotatedAt = time.Now()
+	tm.rotations++
+}
+
+// Status returns the rotation state of the secret
+func (tm *tokenManager) Status() TokenStatus {
+	tm.lock.RLock()
+	defer tm.lock.RUnlock()
+	return TokenStatus{
+		Interval:     tm.interval,
+		LastRotation: tm.rotatedAt,
+		NextRotation: tm.rotatedAt.Add(tm.interval),
+		Rotations:    tm.rotations,
+	}
+}
+
+// TokenStatus returns the rotation state of the secret that store tokens are made from. It returns the zero value if
+// the DHT is not started.
+func (dht *DHT) TokenStatus() TokenStatus {
+	if dht.node == nil {
+		return TokenStatus{}
+	}
+	return dht.node.tokens.Status()
 }
@@ -61,6 +61,11 @@ func (s *Group) Ch() Chan {
 	return s.ctx.Done()
 }
 
+// Ctx returns a context that is canceled when Stop is called.
+func (s *Group) Ctx() context.Context {
+	return s.ctx
+}
+
 // Stop signals any listening processes to stop. After the first call, Stop() does nothing.
 func (s *Group) Stop() {
 	s.cancel()
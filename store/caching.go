@@ -0,0 +1,71 @@
+package store
+
+import (
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CachingStore puts a fast store in front of a slow one. Blobs are read from the cache when possible, and blobs read
+// from the origin are added to the cache. The origin is always the source of truth.
+type CachingStore struct {
+	origin BlobStore
+	cache  BlobStore
+}
+
+// NewCachingStore returns a store that caches blobs from origin in cache
+func NewCachingStore(origin, cache BlobStore) *CachingStore {
+	return &CachingStore{origin: origin, cache: cache}
+}
+
+func (c *CachingStore) Has(hash string) (bool, error) {
+	has, err := c.cache.Has(hash)
+	if err == nil && has {
+		return true, nil
+	}
+	return c.origin.Has(hash)
+}
+
+// Get returns the blob from the cache, or gets it from the origin and caches it
+func (c *CachingStore) Get(hash string) (stream.Blob, error) {
+	blob, err := c.cache.Get(hash)
+	if err == nil {
+		return blob, nil
+	}
+
+	blob, err = c.origin.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.cache.Put(hash, blob)
+	if err != nil {
+		// the blob is still good, so failing to cache it should not fail the read
+		log.Errorf("caching blob %s: %s", hash, errors.FullTrace(err))
+	}
+	return blob, nil
+}
+
+// Put stores the blob in the origin and then in the cache
+func (c *CachingStore) Put(hash string, blob stream.Blob) error {
+	err := c.origin.Put(hash, blob)
+	if err != nil {
+		return err
+	}
+	return c.cache.Put(hash, blob)
+}
+
+// Delete removes the blob from both stores
+func (c *CachingStore) Delete(hash string) error {
+	err := c.origin.Delete(hash)
+	if err != nil {
+		return err
+	}
+	return c.cache.Delete(hash)
+}
+
+// List returns the blobs in the origin
+func (c *CachingStore) List() ([]string, error) {
+	return c.origin.List()
+}
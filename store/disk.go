@@ -0,0 +1,127 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+)
+
+// DiskStore stores each blob in its own file. Files are spread across subdirectories named after the first few
+// characters of the hash, so no directory gets too big.
+type DiskStore struct {
+	dir          string
+	prefixLength int
+}
+
+// NewDiskStore returns a store that keeps blobs in dir. Blobs go into subdirectories named after the first
+// prefixLength characters of their hash. A prefixLength of 0 puts all blobs directly in dir.
+func NewDiskStore(dir string, prefixLength int) *DiskStore {
+	if prefixLength < 0 {
+		prefixLength = 0
+	}
+	if prefixLength > stream.BlobHashHexLength {
+		prefixLength = stream.BlobHashHexLength
+	}
+	return &DiskStore{dir: dir, prefixLength: prefixLength}
+}
+
+func (d *DiskStore) path(hash string) string {
+	return filepath.Join(d.dir, hash[:d.prefixLength], hash)
+}
+
+func (d *DiskStore) Has(hash string) (bool, error) {
+	if !ValidHash(hash) {
+		return false, nil
+	}
+	_, err := os.Stat(d.path(hash))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Err(err)
+	}
+	return true, nil
+}
+
+func (d *DiskStore) Get(hash string) (stream.Blob, error) {
+	if !ValidHash(hash) {
+		return nil, errors.Err(ErrBlobNotFound)
+	}
+	blob, err := os.ReadFile(d.path(hash))
+	if os.IsNotExist(err) {
+		return nil, errors.Err(ErrBlobNotFound)
+	} else if err != nil {
+		return nil, errors.Err(err)
+	}
+	return blob, nil
+}
+
+// Put writes the blob to a temporary file and renames it into place, so a partially written blob is never visible
+func (d *DiskStore) Put(hash string, blob stream.Blob) error {
+	err := verify(hash, blob)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(d.path(hash))
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	tmp, err := os.CreateTemp(dir, hash+".tmp*")
+	if err != nil {
+		return errors.Err(err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once the file is renamed
+
+	_, err = tmp.Write(blob)
+	if err != nil {
+		_ = tmp.Close()
+		return errors.Err(err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return errors.Err(err)
+	}
+
+	return errors.Err(os.Rename(tmp.Name(), d.path(hash)))
+}
+
+func (d *DiskStore) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return nil
+	}
+	err := os.Remove(d.path(hash))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Err(err)
+	}
+	return nil
+}
+
+func (d *DiskStore) List() ([]string, error) {
+	var hashes []string
+	err := filepath.WalkDir(d.dir, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == d.dir {
+				return filepath.SkipDir // nothing has been stored yet
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if path != d.dir && d.prefixLength == 0 {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if ValidHash(entry.Name()) {
+			hashes = append(hashes, entry.Name())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Err(err)
+	}
+	return hashes, nil
+}
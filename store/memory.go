@@ -0,0 +1,64 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+)
+
+// MemoryStore keeps blobs in memory
+type MemoryStore struct {
+	mu    sync.RWMutex
+	blobs map[string]stream.Blob
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{blobs: make(map[string]stream.Blob)}
+}
+
+func (m *MemoryStore) Has(hash string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.blobs[hash]
+	return ok, nil
+}
+
+func (m *MemoryStore) Get(hash string) (stream.Blob, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	blob, ok := m.blobs[hash]
+	if !ok {
+		return nil, errors.Err(ErrBlobNotFound)
+	}
+	return blob, nil
+}
+
+func (m *MemoryStore) Put(hash string, blob stream.Blob) error {
+	err := verify(hash, blob)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[hash] = blob
+	return nil
+}
+
+func (m *MemoryStore) Delete(hash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.blobs, hash)
+	return nil
+}
+
+func (m *MemoryStore) List() ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	hashes := make([]string, 0, len(m.blobs))
+	for h := range m.blobs {
+		hashes = append(hashes, h)
+	}
+	return hashes, nil
+}
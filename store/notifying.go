@@ -0,0 +1,66 @@
+package store
+
+import (
+	"sync"
+
+	"github.com/lbryio/lbry.go/v2/stream"
+)
+
+// NotifyingStore wraps a store and tells listeners when blobs are added or deleted
+type NotifyingStore struct {
+	BlobStore
+
+	mu        sync.RWMutex
+	listeners map[int]func(hash string, added bool)
+	nextID    int
+}
+
+// NewNotifyingStore returns a store that notifies listeners about changes to s
+func NewNotifyingStore(s BlobStore) *NotifyingStore {
+	return &NotifyingStore{BlobStore: s}
+}
+
+// Listen registers fn to be called after every successful Put (with added set to true) and Delete (with added set to
+// false). fn is called synchronously, so it should not block for long. The returned function unregisters fn.
+func (n *NotifyingStore) Listen(fn func(hash string, added bool)) func() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.listeners == nil {
+		n.listeners = make(map[int]func(hash string, added bool))
+	}
+	id := n.nextID
+	n.nextID++
+	n.listeners[id] = fn
+
+	return func() {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		delete(n.listeners, id)
+	}
+}
+
+func (n *NotifyingStore) Put(hash string, blob stream.Blob) error {
+	err := n.BlobStore.Put(hash, blob)
+	if err != nil {
+		return err
+	}
+	n.notify(hash, true)
+	return nil
+}
+
+func (n *NotifyingStore) Delete(hash string) error {
+	err := n.BlobStore.Delete(hash)
+	if err != nil {
+		return err
+	}
+	n.notify(hash, false)
+	return nil
+}
+
+func (n *NotifyingStore) notify(hash string, added bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	for _, fn := range n.listeners {
+		fn(hash, added)
+	}
+}
@@ -0,0 +1,55 @@
+// Package store defines how blobs are stored, and has a few stores that can be used on their own or combined
+package store
+
+import (
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+)
+
+// BlobStore stores blobs by their hex-encoded hash
+type BlobStore interface {
+	// Has returns true if the store has the blob
+	Has(hash string) (bool, error)
+	// Get returns the blob, or ErrBlobNotFound if the store does not have it
+	Get(hash string) (stream.Blob, error)
+	// Put stores the blob. The blob must match the hash.
+	Put(hash string, blob stream.Blob) error
+	// Delete removes the blob. Deleting a blob that is not in the store is not an error.
+	Delete(hash string) error
+	// List returns the hashes of all the blobs in the store
+	List() ([]string, error)
+}
+
+// ErrBlobNotFound is returned by Get when the store does not have the blob
+var ErrBlobNotFound = errors.Base("blob not found")
+
+// ErrHashMismatch is returned by Put when the blob does not match its hash
+var ErrHashMismatch = errors.Base("blob does not match hash")
+
+// ValidHash returns true if the hash is a lowercase hex-encoded blob hash. Such a hash can safely be used as a file name.
+func ValidHash(hash string) bool {
+	if len(hash) != stream.BlobHashHexLength {
+		return false
+	}
+	for _, c := range hash {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// verify returns an error if the blob can't be stored under the hash
+func verify(hash string, blob stream.Blob) error {
+	if !ValidHash(hash) {
+		return errors.Err("invalid hash %s", hash)
+	}
+	err := blob.ValidForSend()
+	if err != nil {
+		return errors.Err(err)
+	}
+	if blob.HashHex() != hash {
+		return errors.Err(ErrHashMismatch)
+	}
+	return nil
+}
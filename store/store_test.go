@@ -0,0 +1,190 @@
+package store
+
+import (
+	"bytes"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+	"github.com/lbryio/lbry.go/v2/stream"
+)
+
+func testBlob(t *testing.T) stream.Blob {
+	blob := make(stream.Blob, 1000)
+	_, err := rand.Read(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blob
+}
+
+// testStore runs the same checks against any store
+func testStore(t *testing.T, s BlobStore) {
+	blob := testBlob(t)
+	hash := blob.HashHex()
+
+	has, err := s.Has(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("empty store should not have blob")
+	}
+
+	_, err = s.Get(hash)
+	if !errors.Is(err, ErrBlobNotFound) {
+		t.Errorf("expected ErrBlobNotFound, got %v", err)
+	}
+
+	err = s.Put(hash, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	has, err = s.Has(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !has {
+		t.Error("store should have blob after put")
+	}
+
+	got, err := s.Get(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, blob) {
+		t.Error("stored blob does not match")
+	}
+
+	other := testBlob(t)
+	err = s.Put(other.HashHex(), other)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{hash, other.HashHex()}
+	sort.Strings(hashes)
+	sort.Strings(expected)
+	if len(hashes) != 2 || hashes[0] != expected[0] || hashes[1] != expected[1] {
+		t.Errorf("expected list %v, got %v", expected, hashes)
+	}
+
+	err = s.Delete(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	has, err = s.Has(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has {
+		t.Error("store should not have blob after delete")
+	}
+	err = s.Delete(hash)
+	if err != nil {
+		t.Errorf("deleting a missing blob should not fail: %v", err)
+	}
+
+	err = s.Put(hash, other)
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Errorf("expected ErrHashMismatch for a blob that does not match its hash, got %v", err)
+	}
+	has, _ = s.Has(hash)
+	if has {
+		t.Error("blob with wrong hash should not be stored")
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestDiskStore(t *testing.T) {
+	testStore(t, NewDiskStore(t.TempDir(), 2))
+}
+
+func TestDiskStore_NoPrefix(t *testing.T) {
+	testStore(t, NewDiskStore(t.TempDir(), 0))
+}
+
+func TestDiskStore_Sharding(t *testing.T) {
+	dir := t.TempDir()
+	s := NewDiskStore(dir, 3)
+
+	blob := testBlob(t)
+	hash := blob.HashHex()
+	err := s.Put(hash, blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = os.Stat(filepath.Join(dir, hash[:3], hash))
+	if err != nil {
+		t.Errorf("blob was not stored in its prefix directory: %v", err)
+	}
+
+	hashes, err := NewDiskStore(filepath.Join(dir, "missing"), 3).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hashes) != 0 {
+		t.Error("missing directory should list no blobs")
+	}
+}
+
+func TestCachingStore(t *testing.T) {
+	testStore(t, NewCachingStore(NewMemoryStore(), NewMemoryStore()))
+
+	origin := NewMemoryStore()
+	cache := NewMemoryStore()
+	s := NewCachingStore(origin, cache)
+
+	blob := testBlob(t)
+	err := origin.Put(blob.HashHex(), blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.Get(blob.HashHex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if has, _ := cache.Has(blob.HashHex()); !has {
+		t.Error("blob read from origin should be cached")
+	}
+}
+
+func TestNotifyingStore(t *testing.T) {
+	s := NewNotifyingStore(NewMemoryStore())
+	testStore(t, s)
+
+	type event struct {
+		hash  string
+		added bool
+	}
+	var events []event
+	unlisten := s.Listen(func(hash string, added bool) { events = append(events, event{hash, added}) })
+
+	blob := testBlob(t)
+	_ = s.Put(blob.HashHex(), blob)
+	_ = s.Put(blob.HashHex(), testBlob(t)) // wrong hash, should not notify
+	_ = s.Delete(blob.HashHex())
+
+	if len(events) != 2 || events[0] != (event{blob.HashHex(), true}) || events[1] != (event{blob.HashHex(), false}) {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	unlisten()
+	_ = s.Put(blob.HashHex(), blob)
+	if len(events) != 2 {
+		t.Errorf("expected no events after unregistering, got %v", events[2:])
+	}
+}
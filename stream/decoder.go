@@ -0,0 +1,119 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// Decoder writes the file data of a stream to a writer one blob at a time, so the whole file never has to be in memory
+type Decoder struct {
+	sd *SDBlob
+	// fetch returns the content blob with the given hash
+	fetch func(hash []byte) (Blob, error)
+}
+
+// NewDecoder creates a decoder for the stream described by the sd blob. Content blobs are requested from fetch in
+// order, as they are needed.
+func NewDecoder(sdBlob Blob, fetch func(hash []byte) (Blob, error)) (*Decoder, error) {
+	sd := &SDBlob{}
+	err := sd.FromBlob(sdBlob)
+	if err != nil {
+		return nil, err
+	}
+	return NewDecoderFromSD(sd, fetch)
+}
+
+// NewDecoderFromSD creates a decoder for an sd blob that has already been parsed
+func NewDecoderFromSD(sd *SDBlob, fetch func(hash []byte) (Blob, error)) (*Decoder, error) {
+	err := checkDecodable(sd)
+	if err != nil {
+		return nil, err
+	}
+	return &Decoder{sd: sd, fetch: fetch}, nil
+}
+
+// checkDecodable returns an error if the sd blob can't be used to decode a stream
+func checkDecodable(sd *SDBlob) error {
+	if !sd.IsValid() {
+		return errors.Err("sd blob is not valid")
+	}
+
+	if len(sd.BlobInfos) == 0 || sd.BlobInfos[len(sd.BlobInfos)-1].Length != 0 {
+		return errors.Err("sd blob is missing the terminating 0-length blob")
+	}
+
+	for i, blobInfo := range sd.BlobInfos {
+		if blobInfo.Length == 0 && i != len(sd.BlobInfos)-1 {
+			return errors.Err("got 0-length blob before end of stream")
+		}
+		if blobInfo.BlobNum != i {
+			return errors.Err("blobs are out of order in sd blob")
+		}
+	}
+
+	return nil
+}
+
+// NewReaderDecoder creates a decoder that reads each content blob from the reader returned by open. The reader is
+// closed after the blob is read.
+func NewReaderDecoder(sdBlob Blob, open func(hash []byte) (io.ReadCloser, error)) (*Decoder, error) {
+	return NewDecoder(sdBlob, func(hash []byte) (Blob, error) {
+		r, err := open(hash)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		b, err := io.ReadAll(io.LimitReader(r, MaxBlobSize+1))
+		if err != nil {
+			return nil, errors.Err(err)
+		}
+		return b, nil
+	})
+}
+
+// SDBlob returns the sd blob of the stream being decoded
+func (d *Decoder) SDBlob() *SDBlob {
+	return d.sd
+}
+
+// WriteTo fetches, verifies, and decrypts each content blob in order, and writes its plaintext to w. It returns the
+// number of bytes written. If a blob does not match the hash in the sd blob, decoding stops with an error.
+func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	for _, blobInfo := range d.sd.BlobInfos {
+		if blobInfo.Length == 0 {
+			break // terminating blob
+		}
+
+		data, err := fetchPlaintext(d.fetch, d.sd.Key, blobInfo)
+		if err != nil {
+			return written, err
+		}
+
+		n, err := w.Write(data)
+		written += int64(n)
+		if err != nil {
+			return written, errors.Err(err)
+		}
+	}
+
+	return written, nil
+}
+
+// fetchPlaintext fetches the blob for the blob info, checks its hash, and returns its plaintext
+func fetchPlaintext(fetch func(hash []byte) (Blob, error), key []byte, blobInfo BlobInfo) ([]byte, error) {
+	blob, err := fetch(blobInfo.BlobHash)
+	if err != nil {
+		return nil, errors.Prefix("fetching blob "+hex.EncodeToString(blobInfo.BlobHash), err)
+	}
+
+	if !bytes.Equal(blob.Hash(), blobInfo.BlobHash) {
+		return nil, errors.Err("blob hash doesn't match hash in blobInfo")
+	}
+
+	return blob.Plaintext(key, blobInfo.IV)
+}
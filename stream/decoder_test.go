@@ -0,0 +1,113 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"testing"
+)
+
+func testStreamData(t *testing.T, size int) ([]byte, Stream) {
+	data := make([]byte, size)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, s
+}
+
+func blobsByHash(s Stream) map[string]Blob {
+	blobs := make(map[string]Blob, len(s))
+	for _, b := range s {
+		blobs[b.HashHex()] = b
+	}
+	return blobs
+}
+
+func TestDecoder_WriteTo(t *testing.T) {
+	data, s := testStreamData(t, 3*MaxBlobSize+1234)
+	blobs := blobsByHash(s)
+
+	var fetched []string
+	dec, err := NewDecoder(s[0], func(hash []byte) (Blob, error) {
+		fetched = append(fetched, hex.EncodeToString(hash))
+		return blobs[hex.EncodeToString(hash)], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := dec.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(len(data)) {
+		t.Errorf("expected %d bytes written, got %d", len(data), n)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("decoded data does not match original data")
+	}
+
+	if len(fetched) != len(s)-1 {
+		t.Fatalf("expected %d blobs to be fetched, got %d", len(s)-1, len(fetched))
+	}
+	for i, h := range fetched {
+		if h != s[i+1].HashHex() {
+			t.Errorf("blob %d was fetched out of order", i)
+		}
+	}
+}
+
+func TestDecoder_BadBlob(t *testing.T) {
+	_, s := testStreamData(t, 2*MaxBlobSize)
+	blobs := blobsByHash(s)
+
+	corrupted := make(Blob, len(s[2]))
+	copy(corrupted, s[2])
+	corrupted[0]++
+	blobs[s[2].HashHex()] = corrupted
+
+	dec, err := NewDecoder(s[0], func(hash []byte) (Blob, error) {
+		return blobs[hex.EncodeToString(hash)], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	n, err := dec.WriteTo(buf)
+	if err == nil {
+		t.Fatal("expected an error for a blob that does not match its hash")
+	}
+	if n != maxBlobDataSize {
+		t.Errorf("expected only the first blob to be written, got %d bytes", n)
+	}
+}
+
+func TestReaderDecoder(t *testing.T) {
+	data, s := testStreamData(t, MaxBlobSize+10)
+	blobs := blobsByHash(s)
+
+	dec, err := NewReaderDecoder(s[0], func(hash []byte) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(blobs[hex.EncodeToString(hash)])), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	_, err = dec.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Error("decoded data does not match original data")
+	}
+}
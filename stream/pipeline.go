@@ -0,0 +1,132 @@
+package stream
+
+import (
+	"io"
+	"sync"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// encodePipeline reads the source in one goroutine and encrypts and hashes blobs in several worker goroutines.
+// Jobs are queued in the order they are read, so results can be collected in order no matter which worker
+// finishes first.
+type encodePipeline struct {
+	// jobs in source order, waiting for their results to be collected
+	ordered chan *encodeJob
+	// closed to stop the reader and the workers early
+	stop     chan struct{}
+	stopOnce sync.Once
+	// error that ended the pipeline, returned by every call to Next after it
+	err error
+}
+
+type encodeJob struct {
+	data   []byte
+	iv     []byte
+	err    error // set if reading the source failed. no blob is made for this job
+	result chan encodeResult
+}
+
+type encodeResult struct {
+	blob Blob
+	hash []byte
+	err  error
+}
+
+// startPipeline starts reading the source and making blobs in the background
+func (e *Encoder) startPipeline() {
+	p := &encodePipeline{
+		ordered: make(chan *encodeJob, 2*e.workers), // enough read-ahead to keep all the workers busy
+		stop:    make(chan struct{}),
+	}
+	jobs := make(chan *encodeJob)
+
+	for i := 0; i < e.workers; i++ {
+		go func() {
+			for job := range jobs {
+				blob, err := NewBlob(job.data, e.sd.Key, job.iv)
+				var hash []byte
+				if err == nil {
+					hash = blob.Hash()
+				}
+				job.result <- encodeResult{blob: blob, hash: hash, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		for {
+			buf := make([]byte, maxBlobDataSize)
+			n, err := e.read(buf)
+			if err != nil {
+				select {
+				case p.ordered <- &encodeJob{err: err}:
+				case <-p.stop:
+				}
+				return
+			}
+
+			e.srcHash.Write(buf[:n]) // hashing here keeps it off the goroutine that collects the blobs
+			job := &encodeJob{data: buf[:n], iv: e.nextIV(), result: make(chan encodeResult, 1)}
+			select {
+			case jobs <- job:
+			case <-p.stop:
+				return
+			}
+			select {
+			case p.ordered <- job:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+
+	e.pipeline = p
+}
+
+// nextParallel returns the next blob made by the pipeline. It works like the serial path in Next.
+func (e *Encoder) nextParallel() (Blob, error) {
+	if e.pipeline == nil {
+		e.startPipeline()
+	}
+	p := e.pipeline
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	job := <-p.ordered
+	if job.err != nil {
+		// the reader has exited, so it's safe to use the IVs and the source hash here
+		if errors.Is(job.err, io.EOF) {
+			e.ensureTerminated()
+		}
+		p.err = job.err
+		e.Close()
+		return nil, job.err
+	}
+
+	res := <-job.result
+	if res.err != nil {
+		p.err = res.err
+		e.Close()
+		return nil, res.err
+	}
+
+	e.srcLen += len(job.data)
+	e.sd.addBlobInfo(res.blob.Size(), res.hash, job.iv)
+
+	return res.blob, nil
+}
+
+// Close stops any workers started by the encoder. It's only needed when Next is called directly with more than one
+// worker, and encoding stops before Next returns an error. Next returns an error after Close.
+func (e *Encoder) Close() {
+	if e.pipeline == nil {
+		return
+	}
+	if e.pipeline.err == nil {
+		e.pipeline.err = errors.Err("encoder is closed")
+	}
+	e.pipeline.stopOnce.Do(func() { close(e.pipeline.stop) })
+}
@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"runtime"
+	"testing"
+)
+
+func testIVs(n int) [][]byte {
+	ivs := make([][]byte, n)
+	for i := range ivs {
+		ivs[i] = randIV()
+	}
+	return ivs
+}
+
+func TestEncoder_Workers(t *testing.T) {
+	data := make([]byte, 5*maxBlobDataSize+1234)
+	_, err := rand.Read(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := randIV()
+	ivs := testIVs(7)
+
+	serial, err := NewEncoderWithIVs(bytes.NewReader(data), key, ivs).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enc := NewEncoderWithIVs(bytes.NewReader(data), key, ivs).Workers(4)
+	parallel, err := enc.Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(parallel) != len(serial) {
+		t.Fatalf("expected %d blobs, got %d", len(serial), len(parallel))
+	}
+	for i := range serial {
+		if !bytes.Equal(serial[i], parallel[i]) {
+			t.Errorf("blob %d does not match", i)
+		}
+	}
+	if enc.SourceLen() != len(data) {
+		t.Errorf("expected source length %d, got %d", len(data), enc.SourceLen())
+	}
+	expectedHash := sha512.Sum384(data)
+	if !bytes.Equal(enc.SourceHash(), expectedHash[:]) {
+		t.Error("source hash does not match")
+	}
+
+	var handled []string
+	manifest, err := NewEncoderWithIVs(bytes.NewReader(data), key, ivs).Workers(3).Encode(func(h string, b []byte) error {
+		if Blob(b).HashHex() != h {
+			t.Errorf("handler got wrong hash for blob")
+		}
+		handled = append(handled, h)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest) != len(serial) {
+		t.Fatalf("expected %d hashes in manifest, got %d", len(serial), len(manifest))
+	}
+	for i := range serial {
+		if manifest[i] != serial[i].HashHex() {
+			t.Errorf("manifest entry %d is out of order", i)
+		}
+	}
+	if handled[len(handled)-1] != serial[0].HashHex() {
+		t.Error("sd blob should be handled last")
+	}
+}
+
+func TestEncoder_WorkersClose(t *testing.T) {
+	data := make([]byte, 4*maxBlobDataSize)
+
+	enc := NewEncoder(bytes.NewReader(data)).Workers(2)
+	_, err := enc.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	enc.Close()
+
+	_, err = enc.Next()
+	if err == nil {
+		t.Error("expected an error from Next after Close")
+	}
+}
+
+func benchmarkEncoder(b *testing.B, workers int) {
+	data := make([]byte, 32*maxBlobDataSize)
+	_, err := rand.Read(data)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := NewEncoder(bytes.NewReader(data)).Workers(workers).Encode(func(string, []byte) error { return nil })
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEncoder_Serial(b *testing.B) { benchmarkEncoder(b, 1) }
+
+func BenchmarkEncoder_Parallel(b *testing.B) { benchmarkEncoder(b, runtime.NumCPU()) }
@@ -0,0 +1,191 @@
+package stream
+
+import (
+	"container/list"
+	"io"
+
+	"github.com/lbryio/lbry.go/v2/extras/errors"
+)
+
+// number of decrypted blobs a ReadSeeker keeps in memory by default
+const defaultReadSeekerCacheSize = 4
+
+// ReadSeeker gives random access to the file data of a stream. Only the blobs that are needed to serve a read are
+// fetched and decrypted, so it can be used with http.ServeContent to serve range requests.
+//
+// NOTE: the plaintext size of a blob can only be known exactly by decrypting it. To avoid fetching every blob just to
+// find an offset, full blobs are assumed to hold maxBlobDataSize bytes of data. This is how every known encoder makes
+// streams. If a blob turns out to be different, reading from it returns an error.
+type ReadSeeker struct {
+	sd    *SDBlob
+	fetch func(hash []byte) (Blob, error)
+
+	// plaintext size of each content blob, or -1 if it's not known yet
+	sizes []int64
+	// current read position in the file data
+	offset int64
+
+	cacheSize int
+	cache     *list.List // most recently used blob first
+}
+
+type cachedBlob struct {
+	num  int
+	data []byte
+}
+
+// NewReadSeeker creates a ReadSeeker for the stream described by the sd blob. Content blobs are requested from fetch
+// when they are first needed.
+func NewReadSeeker(sd *SDBlob, fetch func(hash []byte) (Blob, error)) (*ReadSeeker, error) {
+	err := checkDecodable(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	numBlobs := len(sd.BlobInfos) - 1 // -1 for terminating 0-length blob
+	sizes := make([]int64, numBlobs)
+	for i := range sizes {
+		sizes[i] = -1
+		if sd.BlobInfos[i].Length == MaxBlobSize && i < numBlobs-1 {
+			sizes[i] = maxBlobDataSize
+		}
+	}
+
+	return &ReadSeeker{
+		sd:        sd,
+		fetch:     fetch,
+		sizes:     sizes,
+		cacheSize: defaultReadSeekerCacheSize,
+		cache:     list.New(),
+	}, nil
+}
+
+// CacheSize sets how many decrypted blobs are kept in memory
+func (r *ReadSeeker) CacheSize(n int) *ReadSeeker {
+	if n < 1 {
+		n = 1
+	}
+	r.cacheSize = n
+	r.trimCache()
+	return r
+}
+
+// Size returns the size of the file data. It may need to fetch blobs to find it.
+func (r *ReadSeeker) Size() (int64, error) {
+	var size int64
+	for i := range r.sizes {
+		s, err := r.blobSize(i)
+		if err != nil {
+			return 0, err
+		}
+		size += s
+	}
+	return size, nil
+}
+
+// Read reads data from the current offset. It reads from at most one blob per call.
+func (r *ReadSeeker) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	num, blobOffset, err := r.locate(r.offset)
+	if err != nil {
+		return 0, err
+	}
+	if num < 0 {
+		return 0, io.EOF
+	}
+
+	data, err := r.blobData(num)
+	if err != nil {
+		return 0, err
+	}
+
+	n := copy(p, data[blobOffset:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+// Seek sets the offset for the next Read, as described by io.Seeker
+func (r *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		size, err := r.Size()
+		if err != nil {
+			return r.offset, err
+		}
+		offset += size
+	default:
+		return r.offset, errors.Err("invalid whence %d", whence)
+	}
+
+	if offset < 0 {
+		return r.offset, errors.Err("negative offset")
+	}
+
+	r.offset = offset
+	return offset, nil
+}
+
+// locate returns the blob that holds the byte at the file offset, and the offset of that byte inside the blob's
+// plaintext. If the offset is past the end of the file, the blob number is -1.
+func (r *ReadSeeker) locate(offset int64) (int, int64, error) {
+	var start int64
+	for i := range r.sizes {
+		size, err := r.blobSize(i)
+		if err != nil {
+			return 0, 0, err
+		}
+		if offset < start+size {
+			return i, offset - start, nil
+		}
+		start += size
+	}
+	return -1, 0, nil
+}
+
+// blobSize returns the plaintext size of the blob, decrypting it if the size is not known
+func (r *ReadSeeker) blobSize(num int) (int64, error) {
+	if r.sizes[num] < 0 {
+		_, err := r.blobData(num)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return r.sizes[num], nil
+}
+
+// blobData returns the plaintext of the blob, from the cache if possible
+func (r *ReadSeeker) blobData(num int) ([]byte, error) {
+	for e := r.cache.Front(); e != nil; e = e.Next() {
+		if c := e.Value.(*cachedBlob); c.num == num {
+			r.cache.MoveToFront(e)
+			return c.data, nil
+		}
+	}
+
+	data, err := fetchPlaintext(r.fetch, r.sd.Key, r.sd.BlobInfos[num])
+	if err != nil {
+		return nil, err
+	}
+
+	if r.sizes[num] < 0 {
+		r.sizes[num] = int64(len(data))
+	} else if r.sizes[num] != int64(len(data)) {
+		return nil, errors.Err("blob %d has %d bytes of data, expected %d", num, len(data), r.sizes[num])
+	}
+
+	r.cache.PushFront(&cachedBlob{num: num, data: data})
+	r.trimCache()
+	return data, nil
+}
+
+func (r *ReadSeeker) trimCache() {
+	for r.cache.Len() > r.cacheSize {
+		r.cache.Remove(r.cache.Back())
+	}
+}
@@ -0,0 +1,129 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testReadSeeker(t *testing.T, s Stream, fetched *[]string) *ReadSeeker {
+	blobs := blobsByHash(s)
+
+	sd := &SDBlob{}
+	err := sd.FromBlob(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs, err := NewReadSeeker(sd, func(hash []byte) (Blob, error) {
+		if fetched != nil {
+			*fetched = append(*fetched, hex.EncodeToString(hash))
+		}
+		return blobs[hex.EncodeToString(hash)], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestReadSeeker_Seek(t *testing.T) {
+	data, s := testStreamData(t, 3*MaxBlobSize+500)
+	var fetched []string
+	rs := testReadSeeker(t, s, &fetched)
+
+	// read across the boundary between the second and third blobs
+	start := int64(2*maxBlobDataSize - 100)
+	pos, err := rs.Seek(start, io.SeekStart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos != start {
+		t.Errorf("expected position %d, got %d", start, pos)
+	}
+
+	buf := make([]byte, 300)
+	_, err = io.ReadFull(rs, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[start:start+300]) {
+		t.Error("data read after seek does not match")
+	}
+
+	if len(fetched) != 2 || fetched[0] != s[2].HashHex() || fetched[1] != s[3].HashHex() {
+		t.Errorf("expected only blobs 2 and 3 to be fetched, got %d fetches", len(fetched))
+	}
+
+	end, err := rs.Seek(-10, io.SeekEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end != int64(len(data)-10) {
+		t.Errorf("expected position %d, got %d", len(data)-10, end)
+	}
+	rest, err := io.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, data[len(data)-10:]) {
+		t.Error("data at end of stream does not match")
+	}
+
+	// blob 3 is still cached from the first read
+	if len(fetched) != 3 {
+		t.Errorf("expected 3 fetches, got %d", len(fetched))
+	}
+}
+
+func TestReadSeeker_Cache(t *testing.T) {
+	data, s := testStreamData(t, 3*MaxBlobSize)
+	var fetched []string
+	rs := testReadSeeker(t, s, &fetched).CacheSize(1)
+
+	buf := make([]byte, 10)
+	for _, offset := range []int64{0, 5, maxBlobDataSize + 1, 3} {
+		_, err := rs.Seek(offset, io.SeekStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = io.ReadFull(rs, buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf, data[offset:offset+10]) {
+			t.Errorf("data at offset %d does not match", offset)
+		}
+	}
+
+	if len(fetched) != 3 {
+		t.Errorf("expected 3 fetches with a cache of one blob, got %d", len(fetched))
+	}
+}
+
+func TestReadSeeker_ServeContent(t *testing.T) {
+	data, s := testStreamData(t, 2*MaxBlobSize+10)
+	rs := testReadSeeker(t, s, nil)
+
+	req := httptest.NewRequest(http.MethodGet, "/file", nil)
+	req.Header.Set("Range", "bytes=1000-2999999")
+	rec := httptest.NewRecorder()
+	http.ServeContent(rec, req, "file", time.Time{}, rs)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("expected status %d, got %d", http.StatusPartialContent, rec.Code)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), data[1000:3000000]) {
+		t.Error("range response does not match data")
+	}
+
+	full := httptest.NewRecorder()
+	http.ServeContent(full, httptest.NewRequest(http.MethodGet, "/file", nil), "file", time.Time{}, rs)
+	if !bytes.Equal(full.Body.Bytes(), data) {
+		t.Error("full response does not match data")
+	}
+}
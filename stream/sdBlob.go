@@ -87,13 +87,18 @@ func (s *SDBlob) FromBlob(b Blob) error {
 
 // addBlob adds the blob's info to stream
 func (s *SDBlob) addBlob(b Blob, iv []byte) {
+	s.addBlobInfo(b.Size(), b.Hash(), iv)
+}
+
+// addBlobInfo adds info for a blob whose hash has already been computed
+func (s *SDBlob) addBlobInfo(size int, hash, iv []byte) {
 	if len(iv) == 0 {
 		panic("empty IV")
 	}
 	s.BlobInfos = append(s.BlobInfos, BlobInfo{
 		BlobNum:  len(s.BlobInfos),
-		Length:   b.Size(),
-		BlobHash: b.Hash(),
+		Length:   size,
+		BlobHash: hash,
 		IV:       iv,
 	})
 }
@@ -2,7 +2,9 @@ package stream
 
 import (
 	"bytes"
+	"crypto/aes"
 	"crypto/sha512"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
@@ -28,59 +30,37 @@ func (s Stream) Data() ([]byte, error) {
 	return s.Decode()
 }
 
-// Decode returns the file data that a stream encapsulates
-//
-// TODO: this should use io.Writer instead of returning bytes
+// Decode returns the file data that a stream encapsulates. It holds the whole file in memory, so use a Decoder
+// to write large files somewhere instead.
 func (s Stream) Decode() ([]byte, error) {
 	if len(s) < 2 {
 		return nil, errors.Err("stream must be at least 2 blobs long") // sd blob and content blob
 	}
 
-	sdBlob := &SDBlob{}
-	err := sdBlob.FromBlob(s[0])
+	next := 1
+	dec, err := NewDecoder(s[0], func(hash []byte) (Blob, error) {
+		if next >= len(s) {
+			return nil, errors.Err("number of blobs in stream does not match number of blobs in sd info")
+		}
+		blob := s[next]
+		next++
+		return blob, nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if !sdBlob.IsValid() {
-		return nil, errors.Err("sd blob is not valid")
-	}
-
-	if sdBlob.BlobInfos[len(sdBlob.BlobInfos)-1].Length != 0 {
-		return nil, errors.Err("sd blob is missing the terminating 0-length blob")
-	}
-
-	if len(s[1:]) != len(sdBlob.BlobInfos)-1 { // -1 for terminating 0-length blob
+	if len(s[1:]) != len(dec.SDBlob().BlobInfos)-1 { // -1 for terminating 0-length blob
 		return nil, errors.Err("number of blobs in stream does not match number of blobs in sd info")
 	}
 
-	var file []byte
-	for i, blobInfo := range sdBlob.BlobInfos {
-		if blobInfo.Length == 0 {
-			if i != len(sdBlob.BlobInfos)-1 {
-				return nil, errors.Err("got 0-length blob before end of stream")
-			}
-			break
-		}
-
-		if blobInfo.BlobNum != i {
-			return nil, errors.Err("blobs are out of order in sd blob")
-		}
-
-		blob := s[i+1]
-
-		if !bytes.Equal(blob.Hash(), blobInfo.BlobHash) {
-			return nil, errors.Err("blob hash doesn't match hash in blobInfo")
-		}
-
-		data, err := blob.Plaintext(sdBlob.Key, blobInfo.IV)
-		if err != nil {
-			return nil, err
-		}
-		file = append(file, data...)
+	buf := bytes.NewBuffer(make([]byte, 0, dec.SDBlob().fileSize()))
+	_, err = dec.WriteTo(buf)
+	if err != nil {
+		return nil, err
 	}
 
-	return file, nil
+	return buf.Bytes(), nil
 }
 
 // Encoder reads bytes from a source and returns blobs of the stream
@@ -101,6 +81,13 @@ type Encoder struct {
 	srcLen int
 	// running hash bytes read from src
 	srcHash hash.Hash
+	// number of leading blobs that already existed and were not made again by a partial encoder
+	skipped int
+
+	// number of blobs to encrypt and hash in parallel. 1 means blobs are made one at a time by Next
+	workers int
+	// runs the workers when there is more than one
+	pipeline *encodePipeline
 }
 
 // NewEncoder creates a new stream encoder
@@ -114,6 +101,7 @@ func NewEncoder(src io.Reader) *Encoder {
 			Key:        randIV(),
 		},
 		srcHash: sha512.New384(),
+		workers: 1,
 	}
 }
 
@@ -141,14 +129,40 @@ func NewEncoderFromSD(src io.Reader, sdBlob *SDBlob) *Encoder {
 	return e
 }
 
-// TODO: consider making a NewPartialEncoder that also copies blobinfos from sdBlobs and seeks forward in the data
-// this would avoid re-creating blobs that were created in the past
+// NewPartialEncoder creates an encoder that resumes encoding a stream that was partially encoded in the past. The leading
+// blobs of sdBlob for which exists returns true are not created again. Their data is read from src and skipped, and
+// their infos are copied into the new sd blob. If exists is nil, every blob in sdBlob is assumed to exist. If verify is
+// true, skipped blobs are re-encrypted and their hashes are checked against sdBlob, which is slower but catches a
+// source that changed since the blobs were made.
+//
+// Next only returns the blobs that did not exist yet, so Stream returns an error on a partial encoder. Use Next or
+// Encode instead. The manifest returned by Encode has every blob hash in the stream, but the handler is only called for
+// new blobs. The same NOTE as for NewEncoderFromSD applies.
+func NewPartialEncoder(src io.Reader, sdBlob *SDBlob, exists func(hash []byte) bool, verify bool) (*Encoder, error) {
+	e := NewEncoderFromSD(src, sdBlob)
+
+	for _, info := range sdBlob.BlobInfos {
+		if info.Length == 0 || (exists != nil && !exists(info.BlobHash)) {
+			break
+		}
+		err := e.skip(info, verify)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return e, nil
+}
 
 // Next reads the next chunk of data, encodes it into a blob, and adds it to the stream
 // When the source is fully consumed, Next() makes sure the stream is terminated (i.e. the sd blob
 // ends with an empty terminating blob) and returns io.EOF
 func (e *Encoder) Next() (Blob, error) {
-	n, err := e.src.Read(e.buf)
+	if e.workers > 1 {
+		return e.nextParallel()
+	}
+
+	n, err := e.read(e.buf)
 	if err != nil {
 		if errors.Is(err, io.EOF) {
 			e.ensureTerminated()
@@ -170,9 +184,16 @@ func (e *Encoder) Next() (Blob, error) {
 	return blob, nil
 }
 
-// Stream creates the whole stream in one call
+// Stream creates the whole stream in one call. It returns an error on a partial encoder, since the blobs that already
+// existed would be missing from the stream.
 // TODO: Can be refactored to use Encode method
 func (e *Encoder) Stream() (Stream, error) {
+	defer e.Close()
+
+	if e.skipped > 0 {
+		return nil, errors.Err("partial encoder skipped %d blobs, so it can't make the whole stream", e.skipped)
+	}
+
 	s := make(Stream, 1, 1+int(math.Ceil(float64(e.srcSizeHint)/maxBlobDataSize))) // len starts at 1 and cap is +1 to leave room for sd blob
 
 	for {
@@ -198,9 +219,15 @@ func (e *Encoder) Stream() (Stream, error) {
 	return s, nil
 }
 
-// Encode splits the source into blobs and feeds them into handler function
+// Encode splits the source into blobs and feeds them into handler function. For a partial encoder, the blobs that
+// already existed are in the manifest but are not passed to handler.
 func (e *Encoder) Encode(handler func(string, []byte) error) ([]string, error) {
+	defer e.Close()
+
 	manifest := []string{}
+	for _, info := range e.sd.BlobInfos[:e.skipped] {
+		manifest = append(manifest, hex.EncodeToString(info.BlobHash))
+	}
 
 	for {
 		blob, err := e.Next()
@@ -211,11 +238,12 @@ func (e *Encoder) Encode(handler func(string, []byte) error) ([]string, error) {
 			return nil, err
 		}
 
-		err = handler(blob.HashHex(), blob)
+		h := hex.EncodeToString(e.sd.BlobInfos[len(e.sd.BlobInfos)-1].BlobHash) // already computed when the blob was added
+		err = handler(h, blob)
 		if err != nil {
 			return nil, fmt.Errorf("cannot process blob: %w", err)
 		}
-		manifest = append(manifest, blob.HashHex())
+		manifest = append(manifest, h)
 	}
 
 	sdb := e.SDBlob().ToBlob()
@@ -245,6 +273,19 @@ func (e *Encoder) SourceHash() []byte {
 	return e.srcHash.Sum(nil)
 }
 
+// Workers sets how many blobs are encrypted and hashed in parallel. With more than one worker, the source is read ahead
+// in a separate goroutine while blobs are being made. Blobs are still returned in order, and the resulting stream is the
+// same as with one worker. The source is hashed as it's read, so SourceHash is only accurate once encoding is done.
+// If Next is called directly and encoding stops before Next returns an error, Close must be
+// called to stop the workers.
+func (e *Encoder) Workers(n int) *Encoder {
+	if n < 1 {
+		n = 1
+	}
+	e.workers = n
+	return e
+}
+
 // SourceSizeHint sets a hint about the total size of the source
 // This helps allocate RAM more efficiently.
 // If the hint is wrong, it still works fine but there will be a small performance penalty.
@@ -253,6 +294,57 @@ func (e *Encoder) SourceSizeHint(size int) *Encoder {
 	return e
 }
 
+// skip reads the data for a blob that already exists and adds the blob's info to the sd blob without creating the blob
+func (e *Encoder) skip(info BlobInfo, verify bool) error {
+	n, err := e.read(e.buf)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return errors.Err("source ended before blob %d", info.BlobNum)
+		}
+		return errors.Err(err)
+	}
+
+	// blobs are padded to a whole number of aes blocks, with at least one byte of padding
+	if (n/aes.BlockSize+1)*aes.BlockSize != info.Length {
+		return errors.Err("source data does not match length of blob %d", info.BlobNum)
+	}
+
+	e.srcLen += n
+	e.srcHash.Write(e.buf[:n])
+	iv := e.nextIV()
+
+	if verify {
+		blob, err := NewBlob(e.buf[:n], e.sd.Key, iv)
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(blob.Hash(), info.BlobHash) {
+			return errors.Err("source data does not match hash of blob %d", info.BlobNum)
+		}
+	}
+
+	e.sd.BlobInfos = append(e.sd.BlobInfos, BlobInfo{
+		BlobNum:  len(e.sd.BlobInfos),
+		Length:   info.Length,
+		BlobHash: info.BlobHash,
+		IV:       iv,
+	})
+	e.skipped++
+
+	return nil
+}
+
+// read fills buf from the source, so that every blob but the last is full no matter how the source splits up its
+// data. This keeps blob boundaries the same from one encode to the next, which NewEncoderFromSD and NewPartialEncoder
+// rely on. It returns io.EOF only when there is no data left.
+func (e *Encoder) read(buf []byte) (int, error) {
+	n, err := io.ReadFull(e.src, buf)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return n, nil
+	}
+	return n, err
+}
+
 func (e *Encoder) isTerminated() bool {
 	return len(e.sd.BlobInfos) >= 1 && e.sd.BlobInfos[len(e.sd.BlobInfos)-1].Length == 0
 }
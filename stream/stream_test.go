@@ -10,6 +10,7 @@ import (
 	"os"
 	"path"
 	"testing"
+	"testing/iotest"
 
 	"github.com/lbryio/lbry.go/v2/extras/errors"
 )
@@ -277,3 +278,133 @@ func TestSizeHint(t *testing.T) {
 func TestNew(t *testing.T) {
 	t.Skip("TODO: test new stream creation and decryption")
 }
+
+func TestPartialEncoder(t *testing.T) {
+	data, s := testStreamData(t, 4*maxBlobDataSize+1000)
+
+	sdBlob := &SDBlob{}
+	err := sdBlob.FromBlob(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full, err := NewEncoderFromSD(bytes.NewReader(data), sdBlob).Stream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first two content blobs were made before the publish was interrupted
+	existing := map[string]bool{s[1].HashHex(): true, s[2].HashHex(): true}
+	enc, err := NewPartialEncoder(bytes.NewReader(data), sdBlob, func(hash []byte) bool {
+		return existing[hex.EncodeToString(hash)]
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	made := make(map[string][]byte)
+	manifest, err := enc.Encode(func(h string, b []byte) error {
+		made[h] = b
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest) != len(full) {
+		t.Fatalf("expected %d hashes in the manifest, got %d", len(full), len(manifest))
+	}
+	for i, b := range full {
+		if manifest[i] != b.HashHex() {
+			t.Errorf("hash %d does not match", i)
+		}
+		if existing[b.HashHex()] {
+			if _, ok := made[b.HashHex()]; ok {
+				t.Errorf("blob %d already existed, but was made again", i)
+			}
+		} else if !bytes.Equal(made[b.HashHex()], b) {
+			t.Errorf("blob %d does not match", i)
+		}
+	}
+
+	if enc.SourceLen() != len(data) {
+		t.Errorf("expected source length %d, got %d", len(data), enc.SourceLen())
+	}
+	expectedHash := sha512.Sum384(data)
+	if !bytes.Equal(enc.SourceHash(), expectedHash[:]) {
+		t.Error("source hash does not match")
+	}
+}
+
+func TestPartialEncoder_Interrupted(t *testing.T) {
+	data, s := testStreamData(t, 3*maxBlobDataSize+1000)
+
+	sdBlob := &SDBlob{}
+	err := sdBlob.FromBlob(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// sd blob from a publish that was interrupted after two blobs
+	interrupted := &SDBlob{
+		StreamType: sdBlob.StreamType,
+		Key:        sdBlob.Key,
+		BlobInfos:  sdBlob.BlobInfos[:2],
+	}
+
+	// the source is read in small pieces this time, which must not change where blobs start and end
+	enc, err := NewPartialEncoder(iotest.HalfReader(bytes.NewReader(data)), interrupted, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = enc.Stream()
+	if err == nil {
+		t.Error("expected an error when making the whole stream with a partial encoder")
+	}
+
+	enc, err = NewPartialEncoder(iotest.HalfReader(bytes.NewReader(data)), interrupted, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rest Stream
+	for {
+		blob, err := enc.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rest = append(rest, blob)
+	}
+
+	resumed := append(Stream{enc.SDBlob().ToBlob(), s[1], s[2]}, rest...)
+	decoded, err := resumed.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decoded, data) {
+		t.Error("resumed stream does not decode to the original data")
+	}
+}
+
+func TestPartialEncoder_ChangedSource(t *testing.T) {
+	data, s := testStreamData(t, 2*maxBlobDataSize)
+
+	sdBlob := &SDBlob{}
+	err := sdBlob.FromBlob(s[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data[10]++
+	_, err = NewPartialEncoder(bytes.NewReader(data), sdBlob, nil, true)
+	if err == nil {
+		t.Error("expected an error when the source does not match the existing blobs")
+	}
+
+	_, err = NewPartialEncoder(bytes.NewReader(data[:maxBlobDataSize]), sdBlob, nil, false)
+	if err == nil {
+		t.Error("expected an error when the source is shorter than the existing blobs")
+	}
+}